@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/api"
+	"pet.outbid.goapp/db"
+)
+
+const answerCallbackKey = "answer"
+
+// Actions of the buttons under mention answers.
+const (
+	answerActionRegenerate = "regen"
+	answerActionShorter    = "short"
+	answerActionDetail     = "detail"
+	answerActionSearch     = "search"
+	answerActionTranslate  = "translate"
+)
+
+// answerKeyboard is attached to every mention answer.
+func answerKeyboard() tgbotapi.InlineKeyboardMarkup {
+	button := func(label, action string) tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardButtonData(label, callbackData(answerCallbackKey, action))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			button("Regenerate", answerActionRegenerate),
+			button("Shorter", answerActionShorter),
+			button("More detail", answerActionDetail),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			button("Search the web", answerActionSearch),
+			button("Translate", answerActionTranslate),
+		),
+	)
+}
+
+// saveAnswerRequest stores what is needed to rebuild the request behind a
+// mention answer so the buttons keep working after a restart. Images are
+// kept as Telegram file references and downloaded again when needed.
+func saveAnswerRequest(trigger *tgbotapi.Message, replyMessageID int, answer *mentionAnswer) {
+	r := db.BotRequest{
+		ChatID:           trigger.Chat.ID,
+		ReplyMessageID:   replyMessageID,
+		TriggerMessageID: trigger.MessageID,
+		UserID:           trigger.From.ID,
+		Model:            answer.Model,
+		Question:         answer.Question,
+		HistoryLimit:     answer.HistoryLimit,
+		CreatedAt:        time.Now(),
+	}
+	if len(answer.Media) > 0 {
+		media, err := json.Marshal(answer.Media)
+		if err != nil {
+			log.Printf("Error encoding media of reply %d: %v", replyMessageID, err)
+			return
+		}
+		r.Media = string(media)
+	}
+	if answer.Reasoning != nil {
+		r.Reasoning = *answer.Reasoning
+	}
+	if answer.Verbosity != nil {
+		r.Verbosity = *answer.Verbosity
+	}
+	if err := db.SaveBotRequest(r); err != nil {
+		log.Printf("Error saving request of reply %d: %v", replyMessageID, err)
+	}
+}
+
+// handleAnswerCallback rebuilds the request of a mention answer with options
+// changed by the pressed button and edits the answer in place.
+func handleAnswerCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 1 {
+		answerCallback(callback, "")
+		return
+	}
+	action := args[0]
+	chatID, replyID := callback.Message.Chat.ID, callback.Message.MessageID
+
+	req, err := db.GetBotRequest(chatID, replyID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error loading request of reply %d: %v", replyID, err)
+		}
+		answerCallback(callback, "This answer can't be changed anymore.")
+		return
+	}
+
+	var media []mediaItem
+	if req.Media != "" {
+		if err := json.Unmarshal([]byte(req.Media), &media); err != nil {
+			log.Printf("Error decoding media of reply %d: %v", replyID, err)
+			answerCallback(callback, "This answer can't be changed anymore.")
+			return
+		}
+	}
+
+	model := req.Model
+	var reasoning, verbosity *string
+	if req.Reasoning != "" {
+		reasoning = &req.Reasoning
+	}
+	if req.Verbosity != "" {
+		verbosity = &req.Verbosity
+	}
+
+	// Follow-up actions continue the conversation from the current answer.
+	var followUps []api.Message
+	followUp := func(instruction string) {
+		current := callback.Message.Text
+		if stored, err := db.GetMessage(chatID, replyID); err == nil {
+			current = stored.Text
+		}
+		followUps = append(followUps,
+			api.Message{Role: "assistant", Content: current},
+			api.Message{Role: "user", Content: instruction},
+		)
+	}
+
+	switch action {
+	case answerActionRegenerate:
+	case answerActionShorter:
+		followUp("Rewrite your previous answer to be much shorter. Keep the key points and the language.")
+		if verbosity != nil {
+			low := "low"
+			verbosity = &low
+		}
+	case answerActionDetail:
+		followUp("Expand your previous answer with more detail and examples. Keep the language.")
+		if verbosity != nil {
+			high := "high"
+			verbosity = &high
+		}
+	case answerActionSearch:
+		if len(media) > 0 {
+			answerCallback(callback, "Web search doesn't work with images.")
+			return
+		}
+		model = gptModelForWebSearch
+		// The search model does not accept reasoning/verbosity options
+		reasoning, verbosity = nil, nil
+	case answerActionTranslate:
+		lang := callback.From.LanguageCode
+		if lang == "" {
+			lang = "en"
+		}
+		followUp(fmt.Sprintf("Translate your previous answer into the language with code %q. "+
+			"If it already is in that language, translate it into English. Reply with the translation only.", lang))
+	default:
+		answerCallback(callback, "")
+		return
+	}
+
+	kinds := requestKinds(model == gptModelForWebSearch, len(media) > 0)
+	if err := checkRateLimit(chatID, callback.From.ID, kinds...); err != nil {
+		answerCallback(callback, err.Error())
+		return
+	}
+	answerCallback(callback, "Working on it…")
+
+	stopTyping := keepChatAction(callback.Message.Chat, tgbotapi.ChatTyping)
+	messages, err := buildMentionMessages(chatID, callback.Message.Chat.IsPrivate(), req.TriggerMessageID,
+		req.Question, media, req.HistoryLimit)
+	if err != nil {
+		stopTyping()
+		log.Printf("Error rebuilding request of reply %d (%s): %v", replyID, action, err)
+		return
+	}
+	messages = append(messages, followUps...)
+	completionResponse, err := api.CallChatCompletion(openAIToken, model, messages,
+		api.ChatOptions{Reasoning: reasoning, Verbosity: verbosity})
+	stopTyping()
+	if err != nil {
+		log.Printf("Error re-running request of reply %d (%s): %v", replyID, action, err)
+		return
+	}
+	if len(completionResponse.Choices) == 0 {
+		log.Printf("No choices when re-running request of reply %d (%s)", replyID, action)
+		return
+	}
+	text := messageContentToString(completionResponse.Choices[0].Message.Content)
+
+	keyboard := answerKeyboard()
+	if err := editBotMessage(chatID, replyID, text, &keyboard); err != nil {
+		log.Printf("Error editing reply %d: %v", replyID, err)
+		return
+	}
+	if err := db.EditMessage(chatID, replyID, text, nil, time.Now()); err != nil {
+		log.Printf("Error updating reply %d: %v", replyID, err)
+		return
+	}
+	goBackground(func() { fillAggregatedText(chatID, replyID, text) })
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
 type ChatCompletionRequest struct {
@@ -61,9 +62,15 @@ type ChatOptions struct {
 	TopP      *float32
 	N         *int
 	Store     *bool
+	// Timeout bounds the whole HTTP request; zero means no timeout.
+	Timeout time.Duration
 }
 
 func GetChatCompletion(apiKey string, requestBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	return getChatCompletion(apiKey, requestBody, 0)
+}
+
+func getChatCompletion(apiKey string, requestBody ChatCompletionRequest, timeout time.Duration) (*ChatCompletionResponse, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling request body: %v", err)
@@ -77,7 +84,7 @@ func GetChatCompletion(apiKey string, requestBody ChatCompletionRequest) (*ChatC
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
 
-	client := &http.Client{}
+	client := &http.Client{Timeout: timeout}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error sending HTTP request: %v", err)
@@ -114,5 +121,5 @@ func CallChatCompletion(apiKey string, model string, messages []Message, opts Ch
 		Store:           opts.Store,
 	}
 
-	return GetChatCompletion(apiKey, requestBody)
+	return getChatCompletion(apiKey, requestBody, opts.Timeout)
 }
@@ -1,27 +1,44 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
+	// The runtime image has no zoneinfo; time zones of schedules and
+	// reminders need the embedded one.
+	_ "time/tzdata"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"pet.outbid.goapp/db"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runCLI(os.Args[1:])
+		return
+	}
+
 	initApp()
 
-	go startWebServer()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	webServer := startWebServer()
+	go startRetentionJob()
+	go startScheduler()
+	go startReminderJob()
 
-	handleUpdates()
+	deadline := handleUpdates(ctx)
+	shutdown(deadline, webServer)
 }
 
 func initApp() {
 	botToken := getStringFromEnv("TELEGRAM_BOT_TOKEN")
-	allowedChatID = getInt64FromEnv("ALLOWED_CHAT_ID")
-	testChatID = getInt64FromEnv("TEST_CHAT_ID")
 	adminChatID = getInt64FromEnv("ADMIN_CHAT_ID")
 
 	openAIToken = getStringFromEnv("OPENAI_API_KEY")
@@ -30,6 +47,7 @@ func initApp() {
 	gptModelForGptCommand = getStringFromEnv("GPT_MODEL_FOR_GPT_COMMAND")
 	gptModelForWebSearch = getStringFromEnv("GPT_MODEL_FOR_WEB_SEARCH")
 	fmt.Printf("Bot /gpt model: %s\n", gptModelForGptCommand)
+	initSettingsModels()
 	gptModelForRouting = os.Getenv("GPT_MODEL_FOR_ROUTING")
 	if gptModelForRouting != "" {
 		fmt.Printf("Bot routing model: %s\n", gptModelForRouting)
@@ -44,13 +62,36 @@ func initApp() {
 	botUsername = "@" + bot.Self.UserName
 	fmt.Printf("Bot name: %s\n", botUsername)
 
+	initWebhookConfig()
+
 	if err := db.InitDB(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
+	db.StartMessageWriter(
+		getOptionalIntFromEnv("DB_WRITE_BUFFER", 1000),
+		getOptionalIntFromEnv("DB_WRITE_BATCH", 100),
+		time.Duration(getOptionalIntFromEnv("DB_WRITE_FLUSH_MS", 500))*time.Millisecond,
+	)
+
+	outbox = newOutgoingQueue()
+	dispatcher = newUpdateDispatcher(getOptionalIntFromEnv("UPDATE_WORKERS", 5), 100, func(update tgbotapi.Update) {
+		handleUpdate(bot, update)
+	})
+	loadAllowedChats()
+	initRateLimits()
+	shutdownGrace = time.Duration(getOptionalIntFromEnv("SHUTDOWN_GRACE_SECONDS", int(shutdownGrace/time.Second))) * time.Second
+	progressStatusDelay = time.Duration(getOptionalIntFromEnv("PROGRESS_STATUS_DELAY_SECONDS", int(progressStatusDelay/time.Second))) * time.Second
+	inlineTimeout = time.Duration(getOptionalIntFromEnv("INLINE_TIMEOUT_SECONDS", int(inlineTimeout/time.Second))) * time.Second
+
+	defaultRetention.MaxAgeDays = getOptionalIntFromEnv("RETENTION_MAX_AGE_DAYS", 0)
+	defaultRetention.MaxRows = getOptionalIntFromEnv("RETENTION_MAX_ROWS", 0)
+	botLocation = getLocationFromEnv("TIMEZONE", botLocation)
+	initDigests()
 
 	log.Printf("Authorized on account %s", botUsername)
-	log.Printf("Bot restricted to chat ID: %d", allowedChatID)
-	log.Printf("Bot restricted to TEST chat ID: %d", testChatID)
+
+	registerCommands()
+	publishCommands()
 }
 
 func getStringFromEnv(name string) string {
@@ -69,3 +110,29 @@ func getInt64FromEnv(name string) int64 {
 	}
 	return envVarInt64
 }
+
+// getOptionalIntFromEnv returns the integer value of name, or def when unset.
+func getOptionalIntFromEnv(name string, def int) int {
+	envVar := os.Getenv(name)
+	if envVar == "" {
+		return def
+	}
+	value, err := strconv.Atoi(envVar)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return value
+}
+
+// getLocationFromEnv returns the time zone named by name, or def when unset.
+func getLocationFromEnv(name string, def *time.Location) *time.Location {
+	envVar := os.Getenv(name)
+	if envVar == "" {
+		return def
+	}
+	location, err := time.LoadLocation(envVar)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return location
+}
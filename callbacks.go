@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// callbackData builds inline button data as "<key>:<arg>:<arg>...". Telegram
+// limits it to 64 bytes, so arguments should be short IDs.
+func callbackData(key string, args ...string) string {
+	return strings.Join(append([]string{key}, args...), ":")
+}
+
+// handleCallbackQuery routes inline keyboard presses by the key prefix of
+// their callback data.
+func handleCallbackQuery(callback *tgbotapi.CallbackQuery) {
+	parts := strings.Split(callback.Data, ":")
+
+	switch parts[0] {
+	case searchCallbackKey:
+		handleSearchCallback(callback, parts[1:])
+	case forgetCallbackKey:
+		handleForgetCallback(callback, parts[1:])
+	case settingsCallbackKey:
+		handleSettingsCallback(callback, parts[1:])
+	case chatAccessCallbackKey:
+		handleChatAccessCallback(callback, parts[1:])
+	case answerCallbackKey:
+		handleAnswerCallback(callback, parts[1:])
+	case reminderCallbackKey:
+		handleReminderCallback(callback, parts[1:])
+	default:
+		log.Printf("Unknown callback data: %q", callback.Data)
+		answerCallback(callback, "")
+	}
+}
+
+// answerCallback stops the loading indicator on the pressed button and
+// optionally shows text as a toast.
+func answerCallback(callback *tgbotapi.CallbackQuery, text string) {
+	if _, err := bot.Request(tgbotapi.NewCallback(callback.ID, text)); err != nil {
+		log.Printf("Error answering callback query: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+const (
+	chatAccessCallbackKey = "chat"
+	// unknownChatAlertInterval is the minimum time between two admin alerts
+	// about the same chat.
+	unknownChatAlertInterval = 1 * time.Hour
+	// memberCheckTTL is how long a membership check is trusted.
+	memberCheckTTL = 10 * time.Minute
+)
+
+// chatAccess caches the allowed_chats statuses; unknownChatAlerts remembers
+// when the admin was last alerted about a chat.
+var (
+	chatAccess        = make(map[int64]string)
+	unknownChatAlerts = make(map[int64]time.Time)
+	chatAccessLock    sync.RWMutex
+)
+
+type memberCheck struct {
+	member  bool
+	checked time.Time
+}
+
+// memberChecks caches isAllowedChatMember results by user ID.
+var (
+	memberChecks     = make(map[int64]memberCheck)
+	memberChecksLock sync.Mutex
+)
+
+// loadAllowedChats fills the cache from the database. ALLOWED_CHAT_ID and
+// TEST_CHAT_ID, if set, are added as allowed chats so existing deployments
+// keep working.
+func loadAllowedChats() {
+	for _, name := range []string{"ALLOWED_CHAT_ID", "TEST_CHAT_ID"} {
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		chatID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+		if _, err := db.GetAllowedChat(chatID); errors.Is(err, db.ErrNotFound) {
+			if err := db.SetAllowedChat(db.AllowedChat{ChatID: chatID, Status: db.ChatStatusAllowed, UpdatedAt: time.Now()}); err != nil {
+				log.Fatalf("Failed to allow chat %d from %s: %v", chatID, name, err)
+			}
+		}
+	}
+
+	chats, err := db.ListAllowedChats()
+	if err != nil {
+		log.Fatalf("Failed to load allowed chats: %v", err)
+	}
+	chatAccessLock.Lock()
+	defer chatAccessLock.Unlock()
+	for _, c := range chats {
+		chatAccess[c.ChatID] = c.Status
+		if c.Status == db.ChatStatusAllowed {
+			log.Printf("Bot allowed in chat %d %s", c.ChatID, c.Title)
+		}
+	}
+}
+
+// isAllowedChat reports whether the bot works in chatID. The admin's private
+// chat is always allowed so approvals and admin commands work there.
+func isAllowedChat(chatID int64) bool {
+	if chatID == adminChatID {
+		return true
+	}
+	status, _ := chatStatus(chatID)
+	return status == db.ChatStatusAllowed
+}
+
+// canUseChat reports whether the bot works for user in chat: allowed chats,
+// and private chats of members of an allowed group.
+func canUseChat(chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	if isAllowedChat(chat.ID) {
+		return true
+	}
+	return chat.IsPrivate() && user != nil && isAllowedChatMember(user.ID)
+}
+
+// isAllowedChatMember reports whether the user is in one of the allowed
+// group chats.
+func isAllowedChatMember(userID int64) bool {
+	if userID == adminChatID {
+		return true
+	}
+
+	memberChecksLock.Lock()
+	check, ok := memberChecks[userID]
+	memberChecksLock.Unlock()
+	if ok && time.Since(check.checked) < memberCheckTTL {
+		return check.member
+	}
+
+	member := false
+	for _, chatID := range allowedChatIDs() {
+		if chatID > 0 {
+			// Private chats have no other members.
+			continue
+		}
+		m, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+			ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+		})
+		if err != nil {
+			log.Printf("Error checking membership of user %d in chat %d: %v", userID, chatID, err)
+			continue
+		}
+		if m.IsCreator() || m.IsAdministrator() || m.Status == "member" || (m.Status == "restricted" && m.IsMember) {
+			member = true
+			break
+		}
+	}
+
+	memberChecksLock.Lock()
+	memberChecks[userID] = memberCheck{member: member, checked: time.Now()}
+	memberChecksLock.Unlock()
+	return member
+}
+
+func chatStatus(chatID int64) (string, bool) {
+	chatAccessLock.RLock()
+	defer chatAccessLock.RUnlock()
+	status, ok := chatAccess[chatID]
+	return status, ok
+}
+
+// allowedChatIDs returns the chats the bot is allowed in, in ID order.
+func allowedChatIDs() []int64 {
+	chatAccessLock.RLock()
+	defer chatAccessLock.RUnlock()
+
+	var chatIDs []int64
+	for chatID, status := range chatAccess {
+		if status == db.ChatStatusAllowed {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+	return chatIDs
+}
+
+func setChatStatus(chatID int64, title string, status string, decidedBy int64) error {
+	c, err := db.GetAllowedChat(chatID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	if title != "" {
+		c.Title = title
+	}
+	c.Status = status
+	c.DecidedBy = decidedBy
+	c.UpdatedAt = time.Now()
+	if err := db.SetAllowedChat(c); err != nil {
+		return err
+	}
+
+	chatAccessLock.Lock()
+	chatAccess[chatID] = status
+	delete(unknownChatAlerts, chatID)
+	chatAccessLock.Unlock()
+	return nil
+}
+
+func chatTitle(chat *tgbotapi.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	name := strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+	if chat.UserName != "" {
+		name = strings.TrimSpace(name + " @" + chat.UserName)
+	}
+	return name
+}
+
+// isChatAccessCommand reports whether message is an admin command that has
+// to work in chats that are not allowed yet.
+func isChatAccessCommand(message *tgbotapi.Message) bool {
+	if message.From == nil || message.From.ID != adminChatID || !message.IsCommand() {
+		return false
+	}
+	switch message.Command() {
+	case "allow", "deny", "chats":
+		return true
+	}
+	return false
+}
+
+// handleUnknownChat deals with a message from a chat that is not allowed:
+// new group chats are sent to the admin for approval, rejected ones are left
+// and everything else only produces a rate-limited alert.
+func handleUnknownChat(message *tgbotapi.Message) {
+	chat := message.Chat
+	status, known := chatStatus(chat.ID)
+
+	switch {
+	case !known && !chat.IsPrivate():
+		requestChatApproval(chat)
+	case status == db.ChatStatusRejected && !chat.IsPrivate():
+		leaveChat(chat.ID)
+	default:
+		alertUnknownChat(chat, message.Text)
+	}
+}
+
+// handleMyChatMember asks the admin for approval when the bot is added to a
+// chat it is not allowed in yet.
+func handleMyChatMember(update *tgbotapi.ChatMemberUpdated) {
+	if update.NewChatMember.User == nil || update.NewChatMember.User.ID != bot.Self.ID {
+		return
+	}
+
+	chat := update.Chat
+	switch update.NewChatMember.Status {
+	case "member", "administrator":
+		log.Printf("Bot added to chat %d %q by %d", chat.ID, chatTitle(&chat), update.From.ID)
+		if isAllowedChat(chat.ID) {
+			return
+		}
+		requestChatApproval(&chat)
+	case "left", "kicked":
+		log.Printf("Bot removed from chat %d %q", chat.ID, chatTitle(&chat))
+	}
+}
+
+// requestChatApproval marks the chat as pending and sends the admin one
+// message with Approve/Reject buttons. Chats already pending are skipped.
+func requestChatApproval(chat *tgbotapi.Chat) {
+	if status, _ := chatStatus(chat.ID); status == db.ChatStatusPending {
+		return
+	}
+	title := chatTitle(chat)
+	if err := setChatStatus(chat.ID, title, db.ChatStatusPending, 0); err != nil {
+		log.Printf("Error saving pending chat %d: %v", chat.ID, err)
+		return
+	}
+
+	id := strconv.FormatInt(chat.ID, 10)
+	msg := tgbotapi.NewMessage(adminChatID, fmt.Sprintf("I was added to %s %q (%d). Allow it?", chat.Type, title, chat.ID))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Approve", callbackData(chatAccessCallbackKey, "approve", id)),
+		tgbotapi.NewInlineKeyboardButtonData("Reject", callbackData(chatAccessCallbackKey, "reject", id)),
+	))
+	sendBackgroundMessage(msg, false)
+}
+
+// alertUnknownChat tells the admin about a message from a chat that is not
+// allowed, at most once per unknownChatAlertInterval per chat.
+func alertUnknownChat(chat *tgbotapi.Chat, text string) {
+	chatAccessLock.Lock()
+	last, alerted := unknownChatAlerts[chat.ID]
+	if alerted && time.Since(last) < unknownChatAlertInterval {
+		chatAccessLock.Unlock()
+		log.Printf("Message from not allowed chat: %d, alert already sent", chat.ID)
+		return
+	}
+	unknownChatAlerts[chat.ID] = time.Now()
+	chatAccessLock.Unlock()
+
+	log.Printf("Message from not allowed chat: %d, text: %s", chat.ID, text)
+	alertMsg := tgbotapi.NewMessage(adminChatID, fmt.Sprintf("Message from not allowed %s chat %q (%d). Use /allow %d to let me answer there.",
+		chat.Type, chatTitle(chat), chat.ID, chat.ID))
+	sendBackgroundMessage(alertMsg, false)
+}
+
+func leaveChat(chatID int64) {
+	if _, err := bot.Request(tgbotapi.LeaveChatConfig{ChatID: chatID}); err != nil {
+		log.Printf("Failed to leave chat %d: %v", chatID, err)
+		return
+	}
+	log.Printf("Left chat ID: %d", chatID)
+}
+
+func handleChatAccessCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if callback.From.ID != adminChatID || len(args) != 2 {
+		answerCallback(callback, "Only the bot admin can do this.")
+		return
+	}
+	chatID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		answerCallback(callback, "")
+		return
+	}
+
+	var text string
+	switch args[0] {
+	case "approve":
+		text = allowChat(chatID, "", callback.From.ID)
+	case "reject":
+		text = denyChat(chatID, "", callback.From.ID)
+	default:
+		answerCallback(callback, "")
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
+	if _, err := sendQueued(edit.ChatID, edit, priorityReply); err != nil {
+		log.Printf("Error editing chat approval message: %v", err)
+	}
+	answerCallback(callback, "")
+}
+
+// allowChat and denyChat record the admin decision; title is only updated
+// when not empty.
+func allowChat(chatID int64, title string, adminID int64) string {
+	if err := setChatStatus(chatID, title, db.ChatStatusAllowed, adminID); err != nil {
+		log.Printf("Error allowing chat %d: %v", chatID, err)
+		return fmt.Sprintf("Failed to allow chat %d.", chatID)
+	}
+	return fmt.Sprintf("Chat %d %s is allowed.", chatID, storedChatTitle(chatID))
+}
+
+// denyChat also leaves the chat unless it is a private chat.
+func denyChat(chatID int64, title string, adminID int64) string {
+	if err := setChatStatus(chatID, title, db.ChatStatusRejected, adminID); err != nil {
+		log.Printf("Error rejecting chat %d: %v", chatID, err)
+		return fmt.Sprintf("Failed to reject chat %d.", chatID)
+	}
+	// Group and channel IDs are negative, private chats use the user ID.
+	if chatID < 0 {
+		leaveChat(chatID)
+	}
+	return fmt.Sprintf("Chat %d %s is rejected.", chatID, storedChatTitle(chatID))
+}
+
+func storedChatTitle(chatID int64) string {
+	c, err := db.GetAllowedChat(chatID)
+	if err != nil || c.Title == "" {
+		return ""
+	}
+	return strconv.Quote(c.Title)
+}
+
+// handleAllowCommand handles /allow [chat_id] and /deny [chat_id]; without
+// an argument the current chat is used.
+func handleAllowCommand(message *tgbotapi.Message, allow bool) {
+	chatID, title := message.Chat.ID, chatTitle(message.Chat)
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		// The argument was validated by argsOptionalChatID.
+		chatID, _ = strconv.ParseInt(arg, 10, 64)
+		title = ""
+	}
+
+	if allow {
+		replyToCommand(message, allowChat(chatID, title, message.From.ID))
+	} else {
+		replyToCommand(message, denyChat(chatID, title, message.From.ID))
+	}
+}
+
+func handleChatsCommand(message *tgbotapi.Message) {
+	chats, err := db.ListAllowedChats()
+	if err != nil {
+		log.Printf("Error listing chats: %v", err)
+		return
+	}
+
+	lines := []string{"Known chats:"}
+	for _, c := range chats {
+		lines = append(lines, fmt.Sprintf("%d %s %s", c.ChatID, c.Status, c.Title))
+	}
+	if len(chats) == 0 {
+		lines = []string{"No chats known yet."}
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n"))
+	sendMessage(msg, false)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"pet.outbid.goapp/db"
+)
+
+// runCLI handles maintenance subcommands that only need the database:
+//
+//	app import [-chat ID] result.json
+//	app export -chat ID [-format jsonl|telegram] [-out FILE]
+func runCLI(args []string) {
+	if err := db.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "import":
+		runImportCommand(args[1:])
+	case "export":
+		runExportCommand(args[1:])
+	default:
+		log.Fatalf("Unknown command %q, expected import or export", args[0])
+	}
+}
+
+func runImportCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	chatID := flags.Int64("chat", 0, "store messages under this chat ID instead of the one in the export")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: import [-chat ID] result.json")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Failed to open export: %v", err)
+	}
+	defer file.Close()
+
+	results, err := importTelegramExport(file, *chatID)
+	for _, result := range results {
+		fmt.Printf("Chat %d: %d messages, %d imported, %d already stored\n",
+			result.ChatID, result.Total, result.Imported, result.Skipped)
+	}
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+}
+
+func runExportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	chatID := flags.Int64("chat", 0, "chat ID to export")
+	format := flags.String("format", exportFormatJSONL, "output format: jsonl or telegram")
+	out := flags.String("out", "", "output file (default stdout)")
+	flags.Parse(args)
+	if *chatID == 0 || flags.NArg() != 0 {
+		log.Fatal("Usage: export -chat ID [-format jsonl|telegram] [-out FILE]")
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *out, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := exportHistory(w, *chatID, *format, storedDisplayName); err != nil {
+		log.Fatalf("Export failed: %v", err)
+	}
+}
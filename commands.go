@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// commandScope says where a command can be used. Admin commands are only
+// for the bot admin, in any chat.
+type commandScope int
+
+const (
+	scopeGroup commandScope = 1 << iota
+	scopePrivate
+	scopeAdmin
+
+	scopeAll = scopeGroup | scopePrivate
+)
+
+// commandHandler handles one command message.
+type commandHandler func(message *tgbotapi.Message)
+
+// commandMiddleware wraps a handler, e.g. to check permissions first.
+type commandMiddleware func(cmd *command, next commandHandler) commandHandler
+
+// command is one entry of the command registry.
+type command struct {
+	Name string
+	// Args is the argument syntax shown in /help and usage replies.
+	Args        string
+	Description string
+	Scope       commandScope
+	// ParseArgs validates the arguments before the handler runs; on error
+	// the usage is sent instead. Nil accepts anything.
+	ParseArgs  func(args string) error
+	Middleware []commandMiddleware
+	Handler    commandHandler
+
+	// run is Handler wrapped in the middleware, set by registerCommands.
+	run commandHandler
+}
+
+func (c *command) usage() string {
+	if c.Args == "" {
+		return "/" + c.Name
+	}
+	return "/" + c.Name + " " + c.Args
+}
+
+// commands is the registry in /help order; commandsByName indexes it.
+var (
+	commands       []*command
+	commandsByName map[string]*command
+)
+
+// registerCommands fills the registry. It is a function rather than a
+// variable initializer because /help reads the registry it is part of.
+func registerCommands() {
+	commands = []*command{
+		{Name: "help", Description: "List available commands", Scope: scopeAll,
+			Handler: handleHelpCommand},
+		{Name: "getinfo", Description: "Get your account information", Scope: scopeAll,
+			Handler: handleGetInfoCommand},
+		{Name: "gpt", Args: "<message>", Description: "Forward message to gpt", Scope: scopeAll,
+			ParseArgs:  argsRequired,
+			Middleware: []commandMiddleware{withRateLimit(limitKindRequest)},
+			Handler:    handleGptCommand},
+		{Name: "search", Args: "<words> [from:@name] [after:YYYY-MM-DD] [before:YYYY-MM-DD] [has:photo|video|document|link]",
+			Description: "Search chat history", Scope: scopeAll,
+			ParseArgs: argsRequired,
+			Handler:   handleSearchCommand},
+		{Name: "summarize", Args: "[N | 2h | today | since-my-last-message]",
+			Description: "Summarize the recent discussion", Scope: scopeGroup,
+			ParseArgs:  argsSummaryRange,
+			Middleware: []commandMiddleware{withRateLimit(limitKindRequest)},
+			Handler:    handleSummarizeCommand},
+		{Name: "remind", Args: "<when> <what>", Description: "Remind you of something, e.g. /remind tomorrow at 10 call the vet",
+			Scope: scopeAll, ParseArgs: argsRequired,
+			Middleware: []commandMiddleware{withRateLimit(limitKindRequest)},
+			Handler:    handleRemindCommand},
+		{Name: "reminders", Description: "List and cancel your reminders", Scope: scopeAll,
+			Handler: handleRemindersCommand},
+		{Name: "forget", Args: "[@name]",
+			Description: "Erase your stored messages (reply or add @name to erase someone else's, needs admin confirmation)", Scope: scopeAll,
+			Handler: handleForgetCommand},
+		{Name: "retention", Args: "[<days> <messages> | default]",
+			Description: "Show or change how long history is kept", Scope: scopeAll,
+			Handler: handleRetentionCommand},
+		{Name: "settings", Description: "Show or change the model options of this chat", Scope: scopeAll,
+			Handler: handleSettingsCommand},
+		{Name: "ratelimit", Args: "[exempt|unexempt @name | exemptions]",
+			Description: "Show your usage today or manage exemptions (bot admin)", Scope: scopeAll,
+			Handler: handleRateLimitCommand},
+		{Name: "reset", Description: "Start a new conversation", Scope: scopePrivate,
+			Handler: handleResetCommand},
+		{Name: "allow", Args: "[chat_id]", Description: "Let me work in this or the given chat", Scope: scopeAdmin,
+			ParseArgs: argsOptionalChatID,
+			Handler:   func(message *tgbotapi.Message) { handleAllowCommand(message, true) }},
+		{Name: "deny", Args: "[chat_id]", Description: "Stop working in this or the given chat", Scope: scopeAdmin,
+			ParseArgs: argsOptionalChatID,
+			Handler:   func(message *tgbotapi.Message) { handleAllowCommand(message, false) }},
+		{Name: "chats", Description: "List the chats I know", Scope: scopeAdmin,
+			Handler: handleChatsCommand},
+	}
+
+	commandsByName = make(map[string]*command, len(commands))
+	for _, c := range commands {
+		// Every command is logged and checked for its scope and arguments
+		// before its own middleware runs.
+		middleware := append([]commandMiddleware{withLogging, withScope, withArgs}, c.Middleware...)
+		run := c.Handler
+		for i := len(middleware) - 1; i >= 0; i-- {
+			run = middleware[i](c, run)
+		}
+		c.run = run
+		commandsByName[c.Name] = c
+	}
+}
+
+// handleCommand routes a command message through the registry. Commands
+// addressed to other bots (/cmd@otherbot) are ignored.
+func handleCommand(message *tgbotapi.Message) {
+	name, target, addressed := strings.Cut(message.CommandWithAt(), "@")
+	if addressed && !strings.EqualFold(target, bot.Self.UserName) {
+		return
+	}
+
+	cmd, ok := commandsByName[strings.ToLower(name)]
+	if !ok {
+		handleUnknownCommand(message)
+		return
+	}
+	cmd.run(message)
+}
+
+// replyToCommand answers a command message.
+func replyToCommand(message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	sendMessage(msg, false)
+}
+
+func withLogging(cmd *command, next commandHandler) commandHandler {
+	return func(message *tgbotapi.Message) {
+		start := time.Now()
+		next(message)
+		log.Printf("Command /%s from user %d in chat %d took %s", cmd.Name, message.From.ID, message.Chat.ID,
+			time.Since(start).Round(time.Millisecond))
+	}
+}
+
+func withScope(cmd *command, next commandHandler) commandHandler {
+	return func(message *tgbotapi.Message) {
+		switch {
+		case cmd.Scope&scopeAdmin != 0:
+			if message.From.ID != adminChatID {
+				replyToCommand(message, "Only the bot admin can use /"+cmd.Name+".")
+				return
+			}
+		case message.Chat.IsPrivate() && cmd.Scope&scopePrivate == 0:
+			replyToCommand(message, "/"+cmd.Name+" only works in group chats.")
+			return
+		case !message.Chat.IsPrivate() && cmd.Scope&scopeGroup == 0:
+			replyToCommand(message, "/"+cmd.Name+" only works in a private chat with me.")
+			return
+		}
+		next(message)
+	}
+}
+
+func withArgs(cmd *command, next commandHandler) commandHandler {
+	return func(message *tgbotapi.Message) {
+		if cmd.ParseArgs != nil {
+			if err := cmd.ParseArgs(strings.TrimSpace(message.CommandArguments())); err != nil {
+				replyToCommand(message, fmt.Sprintf("%v. Usage: %s", err, cmd.usage()))
+				return
+			}
+		}
+		next(message)
+	}
+}
+
+// withRateLimit counts the command against the rate limits of kinds.
+func withRateLimit(kinds ...string) commandMiddleware {
+	return func(cmd *command, next commandHandler) commandHandler {
+		return func(message *tgbotapi.Message) {
+			if err := checkRateLimit(message.Chat.ID, message.From.ID, kinds...); err != nil {
+				replyToCommand(message, err.Error())
+				return
+			}
+			next(message)
+		}
+	}
+}
+
+func argsRequired(args string) error {
+	if args == "" {
+		return fmt.Errorf("Arguments missing")
+	}
+	return nil
+}
+
+func argsOptionalChatID(args string) error {
+	if args == "" {
+		return nil
+	}
+	if _, err := strconv.ParseInt(args, 10, 64); err != nil {
+		return fmt.Errorf("%q is not a chat ID", args)
+	}
+	return nil
+}
+
+// visibleCommands returns the commands a user sees in a chat.
+func visibleCommands(private bool, admin bool) []*command {
+	var visible []*command
+	for _, c := range commands {
+		switch {
+		case c.Scope&scopeAdmin != 0:
+			if !admin {
+				continue
+			}
+		case private && c.Scope&scopePrivate == 0, !private && c.Scope&scopeGroup == 0:
+			continue
+		}
+		visible = append(visible, c)
+	}
+	return visible
+}
+
+func handleHelpCommand(message *tgbotapi.Message) {
+	lines := []string{"Available commands:"}
+	for _, c := range visibleCommands(message.Chat.IsPrivate(), message.From.ID == adminChatID) {
+		lines = append(lines, c.usage()+" - "+c.Description)
+	}
+	if message.Chat.IsPrivate() {
+		lines = append(lines, "Just write to me here, every message is for me")
+	} else {
+		lines = append(lines, "Tag me "+botUsername+" if you want to chat with me, or write to me in private")
+	}
+	lines = append(lines,
+		"Type "+botUsername+" <question> in any chat for a quick inline answer",
+		"Если использовать \"загугли\", \"поищи\" или ссылку в сообщении, то будет веб поиск(очень долго думает секунд 30-60)")
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n"))
+	sendMessage(msg, false)
+}
+
+// publishCommands registers the command lists shown by Telegram clients:
+// group and private commands for everyone, plus the admin commands in the
+// admin's private chat.
+func publishCommands() {
+	botCommands := func(cs []*command) []tgbotapi.BotCommand {
+		list := make([]tgbotapi.BotCommand, len(cs))
+		for i, c := range cs {
+			list[i] = tgbotapi.BotCommand{Command: c.Name, Description: c.Description}
+		}
+		return list
+	}
+
+	configs := []tgbotapi.SetMyCommandsConfig{
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllGroupChats(),
+			botCommands(visibleCommands(false, false))...),
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeAllPrivateChats(),
+			botCommands(visibleCommands(true, false))...),
+		tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(adminChatID),
+			botCommands(visibleCommands(true, true))...),
+	}
+	for _, config := range configs {
+		if _, err := bot.Request(config); err != nil {
+			log.Printf("Error publishing commands for scope %s: %v", config.Scope.Type, err)
+		}
+	}
+}
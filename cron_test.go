@@ -0,0 +1,157 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("LoadLocation(%q): %v", name, err)
+	}
+	return location
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{expr: "0 21 * * *"},
+		{expr: "*/15 9-17 * * 1-5"},
+		{expr: "0 9-17/2 1,15 * *"},
+		{expr: "30 8 * * 7"},
+		{expr: "0 0 29 2 *"},
+		{expr: "0 21 * *", wantErr: true},
+		{expr: "0 21 * * * *", wantErr: true},
+		{expr: "60 21 * * *", wantErr: true},
+		{expr: "0 24 * * *", wantErr: true},
+		{expr: "0 0 0 * *", wantErr: true},
+		{expr: "0 0 * 13 *", wantErr: true},
+		{expr: "0 0 * * 8", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "5-1 * * * *", wantErr: true},
+		{expr: "a * * * *", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		_, err := parseCron(tt.expr, time.UTC)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCron(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		location string
+		after    string
+		want     string
+	}{
+		{
+			name: "later today", expr: "0 21 * * *", location: "UTC",
+			after: "2026-03-10T12:34:00Z", want: "2026-03-10T21:00:00Z",
+		},
+		{
+			name: "exactly at the slot moves to the next one", expr: "0 21 * * *", location: "UTC",
+			after: "2026-03-10T21:00:00Z", want: "2026-03-11T21:00:00Z",
+		},
+		{
+			name: "step minutes", expr: "*/15 * * * *", location: "UTC",
+			after: "2026-03-10T12:16:30Z", want: "2026-03-10T12:30:00Z",
+		},
+		{
+			name: "weekdays only", expr: "0 9 * * 1-5", location: "UTC",
+			after: "2026-10-16T10:00:00Z", want: "2026-10-19T09:00:00Z",
+		},
+		{
+			name: "sunday as 7", expr: "0 9 * * 7", location: "UTC",
+			after: "2026-10-16T10:00:00Z", want: "2026-10-18T09:00:00Z",
+		},
+		{
+			name: "day of month or day of week", expr: "0 9 1 * 1", location: "UTC",
+			after: "2026-10-27T10:00:00Z", want: "2026-11-01T09:00:00Z",
+		},
+		{
+			name: "leap day", expr: "0 0 29 2 *", location: "UTC",
+			after: "2026-03-01T00:00:00Z", want: "2028-02-29T00:00:00Z",
+		},
+		{
+			name: "never", expr: "0 0 31 2 *", location: "UTC",
+			after: "2026-03-01T00:00:00Z", want: "",
+		},
+		{
+			name: "half-hour offset", expr: "0 21 * * *", location: "Asia/Kolkata",
+			after: "2026-03-10T12:34:00+05:30", want: "2026-03-10T21:00:00+05:30",
+		},
+		{
+			name: "quarter-hour offset", expr: "0 21 * * *", location: "Asia/Kathmandu",
+			after: "2026-03-10T12:34:00+05:45", want: "2026-03-10T21:00:00+05:45",
+		},
+		{
+			name: "half-hour offset with DST", expr: "0 21 * * *", location: "America/St_Johns",
+			after: "2026-03-10T12:34:00-02:30", want: "2026-03-10T21:00:00-02:30",
+		},
+		{
+			name: "half-hour offset every hour", expr: "0 * * * *", location: "Asia/Kolkata",
+			after: "2026-03-10T12:34:00+05:30", want: "2026-03-10T13:00:00+05:30",
+		},
+		{
+			name: "DST gap skips the missing hour", expr: "30 2 * * *", location: "Europe/Berlin",
+			after: "2026-03-28T12:00:00+01:00", want: "2026-03-30T02:30:00+02:00",
+		},
+		{
+			name: "DST gap keeps following hours", expr: "0 3 * * *", location: "Europe/Berlin",
+			after: "2026-03-28T12:00:00+01:00", want: "2026-03-29T03:00:00+02:00",
+		},
+		{
+			name: "DST repeated hour runs once", expr: "30 2 * * *", location: "Europe/Berlin",
+			after: "2026-10-25T02:40:00+02:00", want: "2026-10-26T02:30:00+01:00",
+		},
+		{
+			name: "DST repeated hour runs twice for hourly schedules", expr: "0 * * * *", location: "Europe/Berlin",
+			after: "2026-10-25T02:40:00+02:00", want: "2026-10-25T02:00:00+01:00",
+		},
+		{
+			name: "after DST ends", expr: "0 21 * * *", location: "Europe/Berlin",
+			after: "2026-10-25T12:00:00+01:00", want: "2026-10-25T21:00:00+01:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			location := mustLoadLocation(t, tt.location)
+			schedule, err := parseCron(tt.expr, location)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", tt.expr, err)
+			}
+			after, err := time.Parse(time.RFC3339, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := schedule.next(after)
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("next(%s) = %s, want none", tt.after, got)
+				}
+				return
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("next(%s) = %s, want %s", tt.after, got, want)
+			}
+			if got.Location() != location {
+				t.Errorf("next(%s) is in %s, want %s", tt.after, got.Location(), location)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Statuses of an allowed_chats row.
+const (
+	ChatStatusAllowed  = "allowed"
+	ChatStatusPending  = "pending"
+	ChatStatusRejected = "rejected"
+)
+
+// AllowedChat is the access decision for one chat.
+type AllowedChat struct {
+	ChatID    int64
+	Title     string
+	Status    string
+	DecidedBy int64
+	UpdatedAt time.Time
+}
+
+type AllowedChatStore interface {
+	// GetAllowedChat returns the chat row or ErrNotFound.
+	GetAllowedChat(chatID int64) (AllowedChat, error)
+	SetAllowedChat(c AllowedChat) error
+	ListAllowedChats() ([]AllowedChat, error)
+}
+
+func GetAllowedChat(chatID int64) (AllowedChat, error) {
+	return store.GetAllowedChat(chatID)
+}
+
+func SetAllowedChat(c AllowedChat) error {
+	return store.SetAllowedChat(c)
+}
+
+func ListAllowedChats() ([]AllowedChat, error) {
+	return store.ListAllowedChats()
+}
+
+const allowedChatColumns = "chat_id, title, status, decided_by, updated_at"
+
+func scanAllowedChat(row rowScanner) (AllowedChat, error) {
+	var c AllowedChat
+	err := row.Scan(&c.ChatID, &c.Title, &c.Status, &c.DecidedBy, &c.UpdatedAt)
+	return c, err
+}
+
+func (s *sqlStore) GetAllowedChat(chatID int64) (AllowedChat, error) {
+	c, err := scanAllowedChat(s.db.QueryRow(`SELECT `+allowedChatColumns+` FROM allowed_chats WHERE chat_id = ?`, chatID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AllowedChat{ChatID: chatID}, ErrNotFound
+	}
+	return c, err
+}
+
+func (s *sqlStore) SetAllowedChat(c AllowedChat) error {
+	query := `INSERT INTO allowed_chats (` + allowedChatColumns + `) VALUES (?, ?, ?, ?, ?)` +
+		s.upsertClause([]string{"chat_id"}, []string{"title", "status", "decided_by", "updated_at"})
+	_, err := s.db.Exec(query, c.ChatID, c.Title, c.Status, c.DecidedBy, c.UpdatedAt.UTC())
+	return err
+}
+
+func (s *sqlStore) ListAllowedChats() ([]AllowedChat, error) {
+	rows, err := s.db.Query(`SELECT ` + allowedChatColumns + ` FROM allowed_chats ORDER BY chat_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []AllowedChat
+	for rows.Next() {
+		c, err := scanAllowedChat(rows)
+		if err != nil {
+			return nil, err
+		}
+		chats = append(chats, c)
+	}
+	return chats, rows.Err()
+}
+
+func (m *memoryStore) GetAllowedChat(chatID int64) (AllowedChat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.allowedChats[chatID]
+	if !ok {
+		return AllowedChat{ChatID: chatID}, ErrNotFound
+	}
+	return c, nil
+}
+
+func (m *memoryStore) SetAllowedChat(c AllowedChat) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.allowedChats == nil {
+		m.allowedChats = make(map[int64]AllowedChat)
+	}
+	m.allowedChats[c.ChatID] = c
+	return nil
+}
+
+func (m *memoryStore) ListAllowedChats() ([]AllowedChat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chats := make([]AllowedChat, 0, len(m.allowedChats))
+	for _, c := range m.allowedChats {
+		chats = append(chats, c)
+	}
+	sort.Slice(chats, func(i, j int) bool { return chats[i].ChatID < chats[j].ChatID })
+	return chats, nil
+}
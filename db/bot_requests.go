@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// BotRequest is the model request behind a bot reply, kept so the reply can
+// be regenerated later. Only the question is kept: the chat history is read
+// again when the request is re-run, and Media is the JSON encoded list of
+// Telegram file references instead of the image data. Empty
+// Reasoning/Verbosity mean the option was not sent.
+type BotRequest struct {
+	ChatID           int64
+	ReplyMessageID   int
+	TriggerMessageID int
+	UserID           int64
+	Model            string
+	Question         string
+	Media            string
+	HistoryLimit     int
+	Reasoning        string
+	Verbosity        string
+	CreatedAt        time.Time
+}
+
+type BotRequestStore interface {
+	// SaveBotRequest inserts or replaces the request of a reply.
+	SaveBotRequest(r BotRequest) error
+	// GetBotRequest returns the request of a reply or ErrNotFound.
+	GetBotRequest(chatID int64, replyMessageID int) (BotRequest, error)
+}
+
+func SaveBotRequest(r BotRequest) error {
+	return store.SaveBotRequest(r)
+}
+
+func GetBotRequest(chatID int64, replyMessageID int) (BotRequest, error) {
+	return store.GetBotRequest(chatID, replyMessageID)
+}
+
+func (s *sqlStore) SaveBotRequest(r BotRequest) error {
+	query := `
+        INSERT INTO bot_requests (chat_id, reply_message_id, trigger_message_id, user_id, model, question, media, history_limit, reasoning, verbosity, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)` +
+		s.upsertClause([]string{"chat_id", "reply_message_id"},
+			[]string{"trigger_message_id", "user_id", "model", "question", "media", "history_limit",
+				"reasoning", "verbosity", "created_at"})
+	_, err := s.db.Exec(query, r.ChatID, r.ReplyMessageID, r.TriggerMessageID, r.UserID, r.Model,
+		r.Question, r.Media, r.HistoryLimit, r.Reasoning, r.Verbosity, r.CreatedAt.UTC())
+	return err
+}
+
+func (s *sqlStore) GetBotRequest(chatID int64, replyMessageID int) (BotRequest, error) {
+	r := BotRequest{ChatID: chatID, ReplyMessageID: replyMessageID}
+	query := `
+        SELECT trigger_message_id, user_id, model, question, media, history_limit, reasoning, verbosity, created_at
+        FROM bot_requests
+        WHERE chat_id = ? AND reply_message_id = ?
+    `
+	err := s.db.QueryRow(query, chatID, replyMessageID).Scan(&r.TriggerMessageID, &r.UserID, &r.Model,
+		&r.Question, &r.Media, &r.HistoryLimit, &r.Reasoning, &r.Verbosity, &r.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+// deleteOrphanRequests removes stored requests whose reply no longer exists.
+func deleteOrphanRequests(tx *sql.Tx, chatID int64) error {
+	query := `
+        DELETE FROM bot_requests
+        WHERE chat_id = ? AND NOT EXISTS (
+            SELECT 1 FROM messages m
+            WHERE m.chat_id = bot_requests.chat_id AND m.message_id = bot_requests.reply_message_id
+        )
+    `
+	_, err := tx.Exec(query, chatID)
+	return err
+}
+
+type botRequestKey struct {
+	chatID         int64
+	replyMessageID int
+}
+
+func (m *memoryStore) SaveBotRequest(r BotRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.botRequests == nil {
+		m.botRequests = make(map[botRequestKey]BotRequest)
+	}
+	m.botRequests[botRequestKey{r.ChatID, r.ReplyMessageID}] = r
+	return nil
+}
+
+func (m *memoryStore) GetBotRequest(chatID int64, replyMessageID int) (BotRequest, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.botRequests[botRequestKey{chatID, replyMessageID}]
+	if !ok {
+		return BotRequest{ChatID: chatID, ReplyMessageID: replyMessageID}, ErrNotFound
+	}
+	return r, nil
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ChatSettings overrides the global model options for one chat. Empty
+// strings and zero values mean "use the default".
+type ChatSettings struct {
+	ChatID       int64
+	Model        string
+	Reasoning    string
+	Verbosity    string
+	HistoryLimit int
+	// SearchMode is "auto" (keyword triggers), "always" or "off".
+	SearchMode string
+	UpdatedBy  int64
+	UpdatedAt  time.Time
+}
+
+type ChatSettingsStore interface {
+	// GetChatSettings returns the chat override or ErrNotFound.
+	GetChatSettings(chatID int64) (ChatSettings, error)
+	SetChatSettings(s ChatSettings) error
+	DeleteChatSettings(chatID int64) error
+}
+
+func GetChatSettings(chatID int64) (ChatSettings, error) {
+	return store.GetChatSettings(chatID)
+}
+
+func SetChatSettings(s ChatSettings) error {
+	return store.SetChatSettings(s)
+}
+
+func DeleteChatSettings(chatID int64) error {
+	return store.DeleteChatSettings(chatID)
+}
+
+func (s *sqlStore) GetChatSettings(chatID int64) (ChatSettings, error) {
+	cs := ChatSettings{ChatID: chatID}
+	query := `
+        SELECT model, reasoning, verbosity, history_limit, search_mode, updated_by, updated_at
+        FROM chat_settings
+        WHERE chat_id = ?
+    `
+	err := s.db.QueryRow(query, chatID).Scan(&cs.Model, &cs.Reasoning, &cs.Verbosity,
+		&cs.HistoryLimit, &cs.SearchMode, &cs.UpdatedBy, &cs.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return cs, ErrNotFound
+	}
+	return cs, err
+}
+
+func (s *sqlStore) SetChatSettings(cs ChatSettings) error {
+	query := `
+        INSERT INTO chat_settings (chat_id, model, reasoning, verbosity, history_limit, search_mode, updated_by, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)` +
+		s.upsertClause([]string{"chat_id"},
+			[]string{"model", "reasoning", "verbosity", "history_limit", "search_mode", "updated_by", "updated_at"})
+	_, err := s.db.Exec(query, cs.ChatID, cs.Model, cs.Reasoning, cs.Verbosity,
+		cs.HistoryLimit, cs.SearchMode, cs.UpdatedBy, cs.UpdatedAt.UTC())
+	return err
+}
+
+func (s *sqlStore) DeleteChatSettings(chatID int64) error {
+	_, err := s.db.Exec(`DELETE FROM chat_settings WHERE chat_id = ?`, chatID)
+	return err
+}
+
+func (m *memoryStore) GetChatSettings(chatID int64) (ChatSettings, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cs, ok := m.chatSettings[chatID]
+	if !ok {
+		return ChatSettings{ChatID: chatID}, ErrNotFound
+	}
+	return cs, nil
+}
+
+func (m *memoryStore) SetChatSettings(cs ChatSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chatSettings == nil {
+		m.chatSettings = make(map[int64]ChatSettings)
+	}
+	m.chatSettings[cs.ChatID] = cs
+	return nil
+}
+
+func (m *memoryStore) DeleteChatSettings(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.chatSettings, chatID)
+	return nil
+}
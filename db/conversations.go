@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ConversationReset marks where a fresh conversation starts: messages up to
+// and including AfterMessageID are no longer used as model context.
+type ConversationReset struct {
+	ChatID         int64
+	AfterMessageID int
+	ResetBy        int64
+	ResetAt        time.Time
+}
+
+type ConversationStore interface {
+	// GetConversationReset returns the last reset of a chat or ErrNotFound.
+	GetConversationReset(chatID int64) (ConversationReset, error)
+	SetConversationReset(r ConversationReset) error
+}
+
+func GetConversationReset(chatID int64) (ConversationReset, error) {
+	return store.GetConversationReset(chatID)
+}
+
+func SetConversationReset(r ConversationReset) error {
+	return store.SetConversationReset(r)
+}
+
+func (s *sqlStore) GetConversationReset(chatID int64) (ConversationReset, error) {
+	r := ConversationReset{ChatID: chatID}
+	query := `SELECT after_message_id, reset_by, reset_at FROM conversation_resets WHERE chat_id = ?`
+	err := s.db.QueryRow(query, chatID).Scan(&r.AfterMessageID, &r.ResetBy, &r.ResetAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *sqlStore) SetConversationReset(r ConversationReset) error {
+	query := `
+        INSERT INTO conversation_resets (chat_id, after_message_id, reset_by, reset_at)
+        VALUES (?, ?, ?, ?)` +
+		s.upsertClause([]string{"chat_id"}, []string{"after_message_id", "reset_by", "reset_at"})
+	_, err := s.db.Exec(query, r.ChatID, r.AfterMessageID, r.ResetBy, r.ResetAt.UTC())
+	return err
+}
+
+func (m *memoryStore) GetConversationReset(chatID int64) (ConversationReset, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.resets[chatID]
+	if !ok {
+		return ConversationReset{ChatID: chatID}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (m *memoryStore) SetConversationReset(r ConversationReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.resets == nil {
+		m.resets = make(map[int64]ConversationReset)
+	}
+	m.resets[r.ChatID] = r
+	return nil
+}
@@ -0,0 +1,318 @@
+package db
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryEdit struct {
+	chatID    int64
+	messageID int
+	oldText   string
+	editedAt  time.Time
+}
+
+// memoryStore keeps everything in process memory. It is meant for tests and
+// throwaway local runs; nothing survives a restart.
+type memoryStore struct {
+	mu        sync.RWMutex
+	messages  []Message
+	edits     []memoryEdit
+	prompts   []Prompt
+	retention map[int64]RetentionPolicy
+	users     map[int64]User
+	// chatSettings holds per-chat overrides by chat ID.
+	chatSettings map[int64]ChatSettings
+	usage        map[UsageKey]int
+	exemptions   map[int64]bool
+	allowedChats map[int64]AllowedChat
+	botRequests  map[botRequestKey]BotRequest
+	resets       map[int64]ConversationReset
+	scheduleRuns map[scheduleRunKey]time.Time
+	reminders    map[int64]Reminder
+	messageParts map[messagePartsKey][]int
+	// lastMessageID and lastReminderID mimic the AUTOINCREMENT of the
+	// messages and reminders tables.
+	lastMessageID  int64
+	lastReminderID int64
+	// nameHistory holds previous profiles; UpdatedAt is when they changed.
+	nameHistory []User
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{}
+}
+
+func (m *memoryStore) Close() error {
+	return nil
+}
+
+func (m *memoryStore) SaveMessage(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.appendMessageLocked(msg)
+	return nil
+}
+
+func (m *memoryStore) SaveMessages(msgs []Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, msg := range msgs {
+		m.appendMessageLocked(msg)
+	}
+	return nil
+}
+
+// appendMessageLocked stores msg under a new row ID. The caller must hold m.mu.
+func (m *memoryStore) appendMessageLocked(msg Message) {
+	m.lastMessageID++
+	msg.ID = m.lastMessageID
+	m.messages = append(m.messages, msg)
+}
+
+func (m *memoryStore) SetAggregatedText(chatID int64, messageID int, aggregatedText string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := &m.messages[i]
+		if msg.ChatID == chatID && msg.MessageID == messageID {
+			msg.AggregatedText = &aggregatedText
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) GetLastMessages(chatID int64, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var chatMessages []Message
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID {
+			chatMessages = append(chatMessages, msg)
+		}
+	}
+
+	sort.SliceStable(chatMessages, func(i, j int) bool {
+		return chatMessages[i].Date.After(chatMessages[j].Date)
+	})
+	if len(chatMessages) > limit {
+		chatMessages = chatMessages[:limit]
+	}
+	sort.SliceStable(chatMessages, func(i, j int) bool {
+		return chatMessages[i].MessageID < chatMessages[j].MessageID
+	})
+
+	return chatMessages, nil
+}
+
+func (m *memoryStore) GetMessage(chatID int64, messageID int) (Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ChatID == chatID && m.messages[i].MessageID == messageID {
+			return m.messages[i], nil
+		}
+	}
+	return Message{}, ErrNotFound
+}
+
+func (m *memoryStore) EditMessage(chatID int64, messageID int, text string, aggregatedText *string, editedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := &m.messages[i]
+		if msg.ChatID != chatID || msg.MessageID != messageID {
+			continue
+		}
+		m.edits = append(m.edits, memoryEdit{
+			chatID:    chatID,
+			messageID: messageID,
+			oldText:   msg.Text,
+			editedAt:  editedAt,
+		})
+		msg.Text = text
+		msg.AggregatedText = aggregatedText
+		msg.EditedAt = editedAt
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (m *memoryStore) GetBotReply(chatID int64, triggerMessageID int) (Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].ChatID == chatID && m.messages[i].TriggerMessageID == triggerMessageID {
+			return m.messages[i], nil
+		}
+	}
+	return Message{}, ErrNotFound
+}
+
+func (m *memoryStore) SearchMessages(q SearchQuery) ([]Message, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	terms := strings.Fields(strings.ToLower(q.Text))
+
+	var matches []Message
+	for _, msg := range m.messages {
+		if msg.ChatID != q.ChatID || (q.UserID != 0 && msg.UserID != q.UserID) {
+			continue
+		}
+		if (!q.After.IsZero() && msg.Date.Before(q.After)) || (!q.Before.IsZero() && !msg.Date.Before(q.Before)) {
+			continue
+		}
+		lower := strings.ToLower(msg.Text)
+		if q.MediaType == "link" {
+			if !strings.Contains(lower, "http://") && !strings.Contains(lower, "https://") && !strings.Contains(lower, "www.") {
+				continue
+			}
+		} else if q.MediaType != "" && msg.MediaType != q.MediaType {
+			continue
+		}
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(lower, term) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, msg)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Date.After(matches[j].Date)
+	})
+
+	total := len(matches)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	matches = matches[q.Offset:]
+	if q.Limit > 0 && len(matches) > q.Limit {
+		matches = matches[:q.Limit]
+	}
+	return matches, total, nil
+}
+
+func (m *memoryStore) ListMessages(chatID int64, afterID int64, limit int) ([]Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Messages are appended in row ID order.
+	var page []Message
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID && msg.ID > afterID {
+			page = append(page, msg)
+		}
+	}
+	if len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+func (m *memoryStore) ListMessageChatIDs() ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := make(map[int64]bool)
+	var chatIDs []int64
+	for _, msg := range m.messages {
+		if !seen[msg.ChatID] {
+			seen[msg.ChatID] = true
+			chatIDs = append(chatIDs, msg.ChatID)
+		}
+	}
+	sort.Slice(chatIDs, func(i, j int) bool {
+		return chatIDs[i] < chatIDs[j]
+	})
+	return chatIDs, nil
+}
+
+func (m *memoryStore) ImportMessages(msgs []Message) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type key struct {
+		chatID    int64
+		messageID int
+	}
+	existing := make(map[key]bool, len(m.messages))
+	for _, msg := range m.messages {
+		existing[key{msg.ChatID, msg.MessageID}] = true
+	}
+
+	inserted := 0
+	for _, msg := range msgs {
+		if existing[key{msg.ChatID, msg.MessageID}] {
+			continue
+		}
+		existing[key{msg.ChatID, msg.MessageID}] = true
+		m.appendMessageLocked(msg)
+		inserted++
+	}
+	return inserted, nil
+}
+
+func (m *memoryStore) GetLatestPrompt(promptType int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for i := len(m.prompts) - 1; i >= 0; i-- {
+		if m.prompts[i].Type == promptType {
+			return m.prompts[i].Text, nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (m *memoryStore) InsertPrompt(promptText string, promptType int, author string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prompts = append(m.prompts, Prompt{
+		ID:     len(m.prompts) + 1,
+		Type:   promptType,
+		Text:   promptText,
+		Author: author,
+		Date:   time.Now(),
+	})
+	return nil
+}
+
+func (m *memoryStore) ListPrompts(promptType int) ([]Prompt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var prompts []Prompt
+	for i := len(m.prompts) - 1; i >= 0; i-- {
+		if m.prompts[i].Type == promptType {
+			prompts = append(prompts, m.prompts[i])
+		}
+	}
+	return prompts, nil
+}
+
+func (m *memoryStore) GetPrompt(id int) (Prompt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if id < 1 || id > len(m.prompts) {
+		return Prompt{}, ErrNotFound
+	}
+	return m.prompts[id-1], nil
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// MessagePartStore remembers the follow-up messages of bot answers that were
+// split over several messages, so a later edit of the answer can replace
+// them.
+type MessagePartStore interface {
+	// SetMessageParts replaces the follow-up message IDs of a message, in
+	// order. An empty list forgets them.
+	SetMessageParts(chatID int64, messageID int, partIDs []int) error
+	// GetMessageParts returns the follow-up message IDs, or none.
+	GetMessageParts(chatID int64, messageID int) ([]int, error)
+}
+
+func SetMessageParts(chatID int64, messageID int, partIDs []int) error {
+	return store.SetMessageParts(chatID, messageID, partIDs)
+}
+
+func GetMessageParts(chatID int64, messageID int) ([]int, error) {
+	return store.GetMessageParts(chatID, messageID)
+}
+
+func (s *sqlStore) SetMessageParts(chatID int64, messageID int, partIDs []int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM message_parts WHERE chat_id = ? AND message_id = ?`, chatID, messageID); err != nil {
+		return err
+	}
+	for i, partID := range partIDs {
+		query := `INSERT INTO message_parts (chat_id, message_id, position, part_message_id) VALUES (?, ?, ?, ?)`
+		if _, err := tx.Exec(query, chatID, messageID, i, partID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetMessageParts(chatID int64, messageID int) ([]int, error) {
+	query := `SELECT part_message_id FROM message_parts WHERE chat_id = ? AND message_id = ? ORDER BY position`
+	rows, err := s.db.Query(query, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partIDs []int
+	for rows.Next() {
+		var partID int
+		if err := rows.Scan(&partID); err != nil {
+			return nil, err
+		}
+		partIDs = append(partIDs, partID)
+	}
+	return partIDs, rows.Err()
+}
+
+// deleteOrphanParts removes the parts of messages that no longer exist.
+func deleteOrphanParts(tx *sql.Tx, chatID int64) error {
+	query := `
+        DELETE FROM message_parts
+        WHERE chat_id = ? AND NOT EXISTS (
+            SELECT 1 FROM messages m
+            WHERE m.chat_id = message_parts.chat_id AND m.message_id = message_parts.message_id
+        )
+    `
+	_, err := tx.Exec(query, chatID)
+	return err
+}
+
+type messagePartsKey struct {
+	chatID    int64
+	messageID int
+}
+
+func (m *memoryStore) SetMessageParts(chatID int64, messageID int, partIDs []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := messagePartsKey{chatID, messageID}
+	if len(partIDs) == 0 {
+		delete(m.messageParts, key)
+		return nil
+	}
+	if m.messageParts == nil {
+		m.messageParts = make(map[messagePartsKey][]int)
+	}
+	m.messageParts[key] = append([]int(nil), partIDs...)
+	return nil
+}
+
+func (m *memoryStore) GetMessageParts(chatID int64, messageID int) ([]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]int(nil), m.messageParts[messagePartsKey{chatID, messageID}]...), nil
+}
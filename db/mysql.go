@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// openMySQL connects to MySQL and brings the schema up to date.
+func openMySQL(dsn string) (Store, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing MySQL DSN: %v", err)
+	}
+	cfg.ParseTime = true
+
+	conn, err := sql.Open("mysql", cfg.FormatDSN())
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error connecting to database: %v", err)
+	}
+
+	s := &sqlStore{db: conn, dialect: dialectMySQL}
+	if err := s.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
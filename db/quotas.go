@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Usage scopes of daily counters.
+const (
+	UsageScopeUser = "user"
+	UsageScopeChat = "chat"
+)
+
+// UsageKey identifies one daily usage counter. Day is formatted as
+// 2006-01-02 in the bot's local time zone.
+type UsageKey struct {
+	Day       string
+	Scope     string
+	SubjectID int64
+	Kind      string
+}
+
+// QuotaStore keeps daily usage counters and users exempt from rate limits.
+type QuotaStore interface {
+	GetUsage(key UsageKey) (int, error)
+	// IncrementUsage adds one to the counter and returns the new value.
+	IncrementUsage(key UsageKey) (int, error)
+	// PurgeUsage deletes counters of days before beforeDay.
+	PurgeUsage(beforeDay string) error
+
+	IsRateLimitExempt(userID int64) (bool, error)
+	SetRateLimitExempt(userID int64, exempt bool, addedBy int64) error
+	ListRateLimitExemptions() ([]int64, error)
+}
+
+func GetUsage(key UsageKey) (int, error) {
+	return store.GetUsage(key)
+}
+
+func IncrementUsage(key UsageKey) (int, error) {
+	return store.IncrementUsage(key)
+}
+
+func PurgeUsage(beforeDay string) error {
+	return store.PurgeUsage(beforeDay)
+}
+
+func IsRateLimitExempt(userID int64) (bool, error) {
+	return store.IsRateLimitExempt(userID)
+}
+
+func SetRateLimitExempt(userID int64, exempt bool, addedBy int64) error {
+	return store.SetRateLimitExempt(userID, exempt, addedBy)
+}
+
+func ListRateLimitExemptions() ([]int64, error) {
+	return store.ListRateLimitExemptions()
+}
+
+func (s *sqlStore) GetUsage(key UsageKey) (int, error) {
+	var count int
+	query := `SELECT count FROM usage_counters WHERE day = ? AND scope = ? AND subject_id = ? AND kind = ?`
+	err := s.db.QueryRow(query, key.Day, key.Scope, key.SubjectID, key.Kind).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return count, err
+}
+
+func (s *sqlStore) IncrementUsage(key UsageKey) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO usage_counters (day, scope, subject_id, kind, count) VALUES (?, ?, ?, ?, 1)`
+	if s.dialect == dialectSQLite {
+		query += ` ON CONFLICT (day, scope, subject_id, kind) DO UPDATE SET count = count + 1`
+	} else {
+		query += ` ON DUPLICATE KEY UPDATE count = count + 1`
+	}
+	if _, err := tx.Exec(query, key.Day, key.Scope, key.SubjectID, key.Kind); err != nil {
+		return 0, err
+	}
+
+	var count int
+	query = `SELECT count FROM usage_counters WHERE day = ? AND scope = ? AND subject_id = ? AND kind = ?`
+	if err := tx.QueryRow(query, key.Day, key.Scope, key.SubjectID, key.Kind).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+func (s *sqlStore) PurgeUsage(beforeDay string) error {
+	_, err := s.db.Exec(`DELETE FROM usage_counters WHERE day < ?`, beforeDay)
+	return err
+}
+
+func (s *sqlStore) IsRateLimitExempt(userID int64) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM rate_limit_exemptions WHERE user_id = ?`, userID).Scan(&n)
+	return n > 0, err
+}
+
+func (s *sqlStore) SetRateLimitExempt(userID int64, exempt bool, addedBy int64) error {
+	if !exempt {
+		_, err := s.db.Exec(`DELETE FROM rate_limit_exemptions WHERE user_id = ?`, userID)
+		return err
+	}
+	query := `INSERT INTO rate_limit_exemptions (user_id, added_by, added_at) VALUES (?, ?, ?)` +
+		s.upsertClause([]string{"user_id"}, []string{"added_by", "added_at"})
+	_, err := s.db.Exec(query, userID, addedBy, time.Now().UTC())
+	return err
+}
+
+func (s *sqlStore) ListRateLimitExemptions() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT user_id FROM rate_limit_exemptions ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+func (m *memoryStore) GetUsage(key UsageKey) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.usage[key], nil
+}
+
+func (m *memoryStore) IncrementUsage(key UsageKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.usage == nil {
+		m.usage = make(map[UsageKey]int)
+	}
+	m.usage[key]++
+	return m.usage[key], nil
+}
+
+func (m *memoryStore) PurgeUsage(beforeDay string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.usage {
+		if key.Day < beforeDay {
+			delete(m.usage, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryStore) IsRateLimitExempt(userID int64) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.exemptions[userID], nil
+}
+
+func (m *memoryStore) SetRateLimitExempt(userID int64, exempt bool, addedBy int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !exempt {
+		delete(m.exemptions, userID)
+		return nil
+	}
+	if m.exemptions == nil {
+		m.exemptions = make(map[int64]bool)
+	}
+	m.exemptions[userID] = true
+	return nil
+}
+
+func (m *memoryStore) ListRateLimitExemptions() ([]int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userIDs := make([]int64, 0, len(m.exemptions))
+	for userID := range m.exemptions {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+	return userIDs, nil
+}
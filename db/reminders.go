@@ -0,0 +1,213 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Reminder is a message to send to a user at DueAt, as a reply to the
+// message that asked for it. Recurring reminders have a cron expression in
+// Recurrence, evaluated in Timezone, and move to their next time after
+// firing; one-off reminders are deleted.
+type Reminder struct {
+	ID         int64
+	ChatID     int64
+	UserID     int64
+	MessageID  int
+	Text       string
+	DueAt      time.Time
+	Recurrence string
+	Timezone   string
+	CreatedAt  time.Time
+}
+
+type ReminderStore interface {
+	// AddReminder stores r and returns its ID.
+	AddReminder(r Reminder) (int64, error)
+	// GetReminder returns the reminder or ErrNotFound.
+	GetReminder(id int64) (Reminder, error)
+	// ListReminders returns the reminders of a user in a chat, soonest first.
+	ListReminders(chatID int64, userID int64) ([]Reminder, error)
+	// GetDueReminders returns up to limit reminders due at or before t,
+	// oldest first.
+	GetDueReminders(t time.Time, limit int) ([]Reminder, error)
+	RescheduleReminder(id int64, dueAt time.Time) error
+	DeleteReminder(id int64) error
+}
+
+func AddReminder(r Reminder) (int64, error) {
+	return store.AddReminder(r)
+}
+
+func GetReminder(id int64) (Reminder, error) {
+	return store.GetReminder(id)
+}
+
+func ListReminders(chatID int64, userID int64) ([]Reminder, error) {
+	return store.ListReminders(chatID, userID)
+}
+
+func GetDueReminders(t time.Time, limit int) ([]Reminder, error) {
+	return store.GetDueReminders(t, limit)
+}
+
+func RescheduleReminder(id int64, dueAt time.Time) error {
+	return store.RescheduleReminder(id, dueAt)
+}
+
+func DeleteReminder(id int64) error {
+	return store.DeleteReminder(id)
+}
+
+// reminderColumns is the column list understood by scanReminder.
+const reminderColumns = `id, chat_id, user_id, message_id, text, due_at, recurrence, timezone, created_at`
+
+func scanReminder(row rowScanner) (Reminder, error) {
+	var r Reminder
+	err := row.Scan(&r.ID, &r.ChatID, &r.UserID, &r.MessageID, &r.Text, &r.DueAt, &r.Recurrence, &r.Timezone, &r.CreatedAt)
+	return r, err
+}
+
+func (s *sqlStore) queryReminders(query string, args ...any) ([]Reminder, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reminders []Reminder
+	for rows.Next() {
+		r, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, r)
+	}
+	return reminders, rows.Err()
+}
+
+func (s *sqlStore) AddReminder(r Reminder) (int64, error) {
+	query := `
+        INSERT INTO reminders (chat_id, user_id, message_id, text, due_at, recurrence, timezone, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := s.db.Exec(query, r.ChatID, r.UserID, r.MessageID, r.Text, r.DueAt.UTC(), r.Recurrence, r.Timezone,
+		r.CreatedAt.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *sqlStore) GetReminder(id int64) (Reminder, error) {
+	row := s.db.QueryRow(`SELECT `+reminderColumns+` FROM reminders WHERE id = ?`, id)
+	r, err := scanReminder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+func (s *sqlStore) ListReminders(chatID int64, userID int64) ([]Reminder, error) {
+	return s.queryReminders(`SELECT `+reminderColumns+` FROM reminders
+        WHERE chat_id = ? AND user_id = ? ORDER BY due_at, id`, chatID, userID)
+}
+
+func (s *sqlStore) GetDueReminders(t time.Time, limit int) ([]Reminder, error) {
+	return s.queryReminders(`SELECT `+reminderColumns+` FROM reminders
+        WHERE due_at <= ? ORDER BY due_at, id LIMIT ?`, t.UTC(), limit)
+}
+
+func (s *sqlStore) RescheduleReminder(id int64, dueAt time.Time) error {
+	_, err := s.db.Exec(`UPDATE reminders SET due_at = ? WHERE id = ?`, dueAt.UTC(), id)
+	return err
+}
+
+func (s *sqlStore) DeleteReminder(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM reminders WHERE id = ?`, id)
+	return err
+}
+
+func (m *memoryStore) AddReminder(r Reminder) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reminders == nil {
+		m.reminders = make(map[int64]Reminder)
+	}
+	m.lastReminderID++
+	r.ID = m.lastReminderID
+	m.reminders[r.ID] = r
+	return r.ID, nil
+}
+
+func (m *memoryStore) GetReminder(id int64) (Reminder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	r, ok := m.reminders[id]
+	if !ok {
+		return Reminder{}, ErrNotFound
+	}
+	return r, nil
+}
+
+func (m *memoryStore) ListReminders(chatID int64, userID int64) ([]Reminder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reminders []Reminder
+	for _, r := range m.reminders {
+		if r.ChatID == chatID && r.UserID == userID {
+			reminders = append(reminders, r)
+		}
+	}
+	sortReminders(reminders)
+	return reminders, nil
+}
+
+func (m *memoryStore) GetDueReminders(t time.Time, limit int) ([]Reminder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reminders []Reminder
+	for _, r := range m.reminders {
+		if !r.DueAt.After(t) {
+			reminders = append(reminders, r)
+		}
+	}
+	sortReminders(reminders)
+	if len(reminders) > limit {
+		reminders = reminders[:limit]
+	}
+	return reminders, nil
+}
+
+func (m *memoryStore) RescheduleReminder(id int64, dueAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.reminders[id]; ok {
+		r.DueAt = dueAt
+		m.reminders[id] = r
+	}
+	return nil
+}
+
+func (m *memoryStore) DeleteReminder(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.reminders, id)
+	return nil
+}
+
+func sortReminders(reminders []Reminder) {
+	sort.Slice(reminders, func(i, j int) bool {
+		if !reminders[i].DueAt.Equal(reminders[j].DueAt) {
+			return reminders[i].DueAt.Before(reminders[j].DueAt)
+		}
+		return reminders[i].ID < reminders[j].ID
+	})
+}
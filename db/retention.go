@@ -0,0 +1,375 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// RetentionPolicy limits how much history is kept for a chat. Zero values
+// mean "no limit".
+type RetentionPolicy struct {
+	ChatID     int64
+	MaxAgeDays int
+	MaxRows    int
+}
+
+type RetentionStore interface {
+	// GetRetentionPolicy returns the chat override or ErrNotFound.
+	GetRetentionPolicy(chatID int64) (RetentionPolicy, error)
+	GetRetentionPolicies() ([]RetentionPolicy, error)
+	SetRetentionPolicy(p RetentionPolicy) error
+	DeleteRetentionPolicy(chatID int64) error
+	// PurgeMessages deletes messages older than olderThan (if not zero) and all
+	// but the newest keepRows messages (if positive), with their edit history.
+	PurgeMessages(chatID int64, olderThan time.Time, keepRows int) (int64, error)
+	// ForgetUser deletes everything stored about userID in the chat: their
+	// messages, edit history, reminders, the bot answers they triggered and
+	// the stored model requests behind them. Their profile and name history
+	// are not kept per chat, so they are deleted only when no chat still
+	// holds messages from the user.
+	ForgetUser(chatID int64, userID int64) (int64, error)
+}
+
+func GetRetentionPolicy(chatID int64) (RetentionPolicy, error) {
+	return store.GetRetentionPolicy(chatID)
+}
+
+func GetRetentionPolicies() ([]RetentionPolicy, error) {
+	return store.GetRetentionPolicies()
+}
+
+func SetRetentionPolicy(p RetentionPolicy) error {
+	return store.SetRetentionPolicy(p)
+}
+
+func DeleteRetentionPolicy(chatID int64) error {
+	return store.DeleteRetentionPolicy(chatID)
+}
+
+func PurgeMessages(chatID int64, olderThan time.Time, keepRows int) (int64, error) {
+	FlushMessages()
+	return store.PurgeMessages(chatID, olderThan, keepRows)
+}
+
+func ForgetUser(chatID int64, userID int64) (int64, error) {
+	FlushMessages()
+	return store.ForgetUser(chatID, userID)
+}
+
+func (s *sqlStore) GetRetentionPolicy(chatID int64) (RetentionPolicy, error) {
+	p := RetentionPolicy{ChatID: chatID}
+	query := `SELECT max_age_days, max_rows FROM retention_policies WHERE chat_id = ?`
+	err := s.db.QueryRow(query, chatID).Scan(&p.MaxAgeDays, &p.MaxRows)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+func (s *sqlStore) GetRetentionPolicies() ([]RetentionPolicy, error) {
+	rows, err := s.db.Query(`SELECT chat_id, max_age_days, max_rows FROM retention_policies ORDER BY chat_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []RetentionPolicy
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.ChatID, &p.MaxAgeDays, &p.MaxRows); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+func (s *sqlStore) SetRetentionPolicy(p RetentionPolicy) error {
+	query := `INSERT INTO retention_policies (chat_id, max_age_days, max_rows) VALUES (?, ?, ?)` +
+		s.upsertClause([]string{"chat_id"}, []string{"max_age_days", "max_rows"})
+	_, err := s.db.Exec(query, p.ChatID, p.MaxAgeDays, p.MaxRows)
+	return err
+}
+
+func (s *sqlStore) DeleteRetentionPolicy(chatID int64) error {
+	_, err := s.db.Exec(`DELETE FROM retention_policies WHERE chat_id = ?`, chatID)
+	return err
+}
+
+func (s *sqlStore) PurgeMessages(chatID int64, olderThan time.Time, keepRows int) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var deleted int64
+	if !olderThan.IsZero() {
+		res, err := tx.Exec(`DELETE FROM messages WHERE chat_id = ? AND date < ?`, chatID, olderThan.UTC())
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	if keepRows > 0 {
+		// The derived table lets MySQL read from the table it deletes from.
+		query := `
+            DELETE FROM messages
+            WHERE chat_id = ? AND id < (
+                SELECT id FROM (
+                    SELECT id FROM messages WHERE chat_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?
+                ) newest
+            )
+        `
+		res, err := tx.Exec(query, chatID, chatID, keepRows-1)
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+
+	if err := deleteOrphanEdits(tx, chatID); err != nil {
+		return 0, err
+	}
+	if err := deleteOrphanRequests(tx, chatID); err != nil {
+		return 0, err
+	}
+	if err := deleteOrphanParts(tx, chatID); err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+func (s *sqlStore) ForgetUser(chatID int64, userID int64) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+        DELETE FROM bot_requests
+        WHERE chat_id = ? AND (user_id = ? OR trigger_message_id IN (
+            SELECT message_id FROM messages WHERE chat_id = ? AND user_id = ?
+        ))
+    `
+	if _, err := tx.Exec(query, chatID, userID, chatID, userID); err != nil {
+		return 0, err
+	}
+
+	query = `
+        DELETE FROM messages
+        WHERE chat_id = ? AND (user_id = ? OR trigger_message_id IN (
+            SELECT message_id FROM (
+                SELECT message_id FROM messages WHERE chat_id = ? AND user_id = ?
+            ) own
+        ))
+    `
+	res, err := tx.Exec(query, chatID, userID, chatID, userID)
+	if err != nil {
+		return 0, err
+	}
+	deleted, _ := res.RowsAffected()
+
+	if _, err := tx.Exec(`DELETE FROM reminders WHERE chat_id = ? AND user_id = ?`, chatID, userID); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"users", "user_name_history"} {
+		query := `DELETE FROM ` + table + ` WHERE user_id = ? AND NOT EXISTS (SELECT 1 FROM messages WHERE user_id = ?)`
+		if _, err := tx.Exec(query, userID, userID); err != nil {
+			return 0, err
+		}
+	}
+	if err := deleteOrphanEdits(tx, chatID); err != nil {
+		return 0, err
+	}
+	if err := deleteOrphanRequests(tx, chatID); err != nil {
+		return 0, err
+	}
+	if err := deleteOrphanParts(tx, chatID); err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+// deleteOrphanEdits removes edit history whose message no longer exists.
+func deleteOrphanEdits(tx *sql.Tx, chatID int64) error {
+	query := `
+        DELETE FROM message_edits
+        WHERE chat_id = ? AND NOT EXISTS (
+            SELECT 1 FROM messages m
+            WHERE m.chat_id = message_edits.chat_id AND m.message_id = message_edits.message_id
+        )
+    `
+	_, err := tx.Exec(query, chatID)
+	return err
+}
+
+func (m *memoryStore) GetRetentionPolicy(chatID int64) (RetentionPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.retention[chatID]
+	if !ok {
+		return RetentionPolicy{ChatID: chatID}, ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *memoryStore) GetRetentionPolicies() ([]RetentionPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	policies := make([]RetentionPolicy, 0, len(m.retention))
+	for _, p := range m.retention {
+		policies = append(policies, p)
+	}
+	sort.Slice(policies, func(i, j int) bool {
+		return policies[i].ChatID < policies[j].ChatID
+	})
+	return policies, nil
+}
+
+func (m *memoryStore) SetRetentionPolicy(p RetentionPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.retention == nil {
+		m.retention = make(map[int64]RetentionPolicy)
+	}
+	m.retention[p.ChatID] = p
+	return nil
+}
+
+func (m *memoryStore) DeleteRetentionPolicy(chatID int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.retention, chatID)
+	return nil
+}
+
+func (m *memoryStore) PurgeMessages(chatID int64, olderThan time.Time, keepRows int) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chatRows := 0
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID {
+			chatRows++
+		}
+	}
+
+	// Messages are appended in insertion order, so the first ones are the oldest rows.
+	excess := 0
+	if keepRows > 0 && chatRows > keepRows {
+		excess = chatRows - keepRows
+	}
+
+	var deleted int64
+	m.deleteMessagesLocked(func(msg Message) bool {
+		if msg.ChatID != chatID {
+			return false
+		}
+		if excess > 0 {
+			excess--
+			deleted++
+			return true
+		}
+		if !olderThan.IsZero() && msg.Date.Before(olderThan) {
+			deleted++
+			return true
+		}
+		return false
+	})
+	return deleted, nil
+}
+
+func (m *memoryStore) ForgetUser(chatID int64, userID int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	own := make(map[int]bool)
+	for _, msg := range m.messages {
+		if msg.ChatID == chatID && msg.UserID == userID {
+			own[msg.MessageID] = true
+		}
+	}
+	for k, r := range m.botRequests {
+		if k.chatID == chatID && (r.UserID == userID || own[r.TriggerMessageID]) {
+			delete(m.botRequests, k)
+		}
+	}
+
+	var deleted int64
+	m.deleteMessagesLocked(func(msg Message) bool {
+		if msg.ChatID == chatID && (msg.UserID == userID || own[msg.TriggerMessageID]) {
+			deleted++
+			return true
+		}
+		return false
+	})
+	for id, r := range m.reminders {
+		if r.ChatID == chatID && r.UserID == userID {
+			delete(m.reminders, id)
+		}
+	}
+	for _, msg := range m.messages {
+		if msg.UserID == userID {
+			return deleted, nil
+		}
+	}
+	delete(m.users, userID)
+	keptHistory := m.nameHistory[:0]
+	for _, u := range m.nameHistory {
+		if u.UserID != userID {
+			keptHistory = append(keptHistory, u)
+		}
+	}
+	m.nameHistory = keptHistory
+	return deleted, nil
+}
+
+// deleteMessagesLocked removes messages matching drop together with their
+// edit history. The caller must hold m.mu.
+func (m *memoryStore) deleteMessagesLocked(drop func(Message) bool) {
+	type key struct {
+		chatID    int64
+		messageID int
+	}
+	removed := make(map[key]bool)
+
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if drop(msg) {
+			removed[key{msg.ChatID, msg.MessageID}] = true
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	m.messages = kept
+
+	keptEdits := m.edits[:0]
+	for _, edit := range m.edits {
+		if !removed[key{edit.chatID, edit.messageID}] {
+			keptEdits = append(keptEdits, edit)
+		}
+	}
+	m.edits = keptEdits
+
+	for k := range m.botRequests {
+		if removed[key{k.chatID, k.replyMessageID}] {
+			delete(m.botRequests, k)
+		}
+	}
+	for k := range m.messageParts {
+		if removed[key{k.chatID, k.messageID}] {
+			delete(m.messageParts, k)
+		}
+	}
+}
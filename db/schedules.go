@@ -0,0 +1,70 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ScheduleStore remembers the last slot each scheduled job ran for, per
+// chat, so a restart neither repeats nor skips a run.
+type ScheduleStore interface {
+	// GetScheduleRun returns the last slot the job ran for in the chat or
+	// ErrNotFound.
+	GetScheduleRun(job string, chatID int64) (time.Time, error)
+	SetScheduleRun(job string, chatID int64, slot time.Time) error
+}
+
+func GetScheduleRun(job string, chatID int64) (time.Time, error) {
+	return store.GetScheduleRun(job, chatID)
+}
+
+func SetScheduleRun(job string, chatID int64, slot time.Time) error {
+	return store.SetScheduleRun(job, chatID, slot)
+}
+
+func (s *sqlStore) GetScheduleRun(job string, chatID int64) (time.Time, error) {
+	var slot time.Time
+	query := `SELECT last_slot FROM schedule_runs WHERE job = ? AND chat_id = ?`
+	err := s.db.QueryRow(query, job, chatID).Scan(&slot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return slot, ErrNotFound
+	}
+	return slot, err
+}
+
+func (s *sqlStore) SetScheduleRun(job string, chatID int64, slot time.Time) error {
+	query := `
+        INSERT INTO schedule_runs (job, chat_id, last_slot, updated_at)
+        VALUES (?, ?, ?, ?)` +
+		s.upsertClause([]string{"job", "chat_id"}, []string{"last_slot", "updated_at"})
+	_, err := s.db.Exec(query, job, chatID, slot.UTC(), time.Now().UTC())
+	return err
+}
+
+type scheduleRunKey struct {
+	job    string
+	chatID int64
+}
+
+func (m *memoryStore) GetScheduleRun(job string, chatID int64) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	slot, ok := m.scheduleRuns[scheduleRunKey{job, chatID}]
+	if !ok {
+		return time.Time{}, ErrNotFound
+	}
+	return slot, nil
+}
+
+func (m *memoryStore) SetScheduleRun(job string, chatID int64, slot time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.scheduleRuns == nil {
+		m.scheduleRuns = make(map[scheduleRunKey]time.Time)
+	}
+	m.scheduleRuns[scheduleRunKey{job, chatID}] = slot
+	return nil
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+)
+
+//go:embed schema_mysql.sql
+var mysqlSchema string
+
+//go:embed schema_sqlite.sql
+var sqliteSchema string
+
+type columnUpgrade struct {
+	table      string
+	column     string
+	definition string
+}
+
+// columnUpgrades lists columns added after a table first shipped, so that
+// databases created from an older schema are brought up to date on start.
+var columnUpgrades = []columnUpgrade{
+	{"messages", "reply_to_message_id", "INT NULL"},
+	{"messages", "trigger_message_id", "INT NULL"},
+	{"messages", "model", "VARCHAR(64) NULL"},
+	{"messages", "edited_at", "DATETIME NULL"},
+	{"messages", "media_type", "VARCHAR(16) NULL"},
+	{"prompts", "author", "VARCHAR(64) NULL"},
+}
+
+type indexUpgrade struct {
+	table string
+	index string
+	// definition is appended to ALTER TABLE, e.g. "ADD INDEX idx (col)".
+	definition string
+}
+
+// mysqlIndexUpgrades lists MySQL indexes added after their table first
+// shipped. SQLite uses CREATE INDEX IF NOT EXISTS in its schema file instead.
+var mysqlIndexUpgrades = []indexUpgrade{
+	{"messages", "ft_text", "ADD FULLTEXT INDEX ft_text (text)"},
+}
+
+// sqliteDataUpgrades run once each, in order, tracked by PRAGMA user_version.
+var sqliteDataUpgrades = []string{
+	// Index rows stored before the full-text table existed.
+	`INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`,
+}
+
+// migrate creates missing tables and adds missing columns. Every statement in
+// the schema files must be idempotent.
+func (s *sqlStore) migrate() error {
+	if err := s.applySchema(); err != nil {
+		return err
+	}
+
+	for _, upgrade := range columnUpgrades {
+		exists, err := s.columnExists(upgrade.table, upgrade.column)
+		if err != nil {
+			return fmt.Errorf("error inspecting %s.%s: %v", upgrade.table, upgrade.column, err)
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", upgrade.table, upgrade.column, upgrade.definition)
+		if _, err := s.db.Exec(query); err != nil {
+			return fmt.Errorf("error adding column %s.%s: %v", upgrade.table, upgrade.column, err)
+		}
+	}
+
+	if s.dialect == dialectSQLite {
+		return s.applySQLiteDataUpgrades()
+	}
+	return s.applyMySQLIndexUpgrades()
+}
+
+func (s *sqlStore) applySchema() error {
+	if s.dialect == dialectSQLite {
+		// SQLite accepts the whole script at once, which keeps trigger bodies intact.
+		if _, err := s.db.Exec(sqliteSchema); err != nil {
+			return fmt.Errorf("error applying schema: %v", err)
+		}
+		return nil
+	}
+
+	for _, stmt := range strings.Split(mysqlSchema, ";") {
+		if strings.TrimSpace(stmt) == "" {
+			continue
+		}
+		if _, err := s.db.Exec(stmt); err != nil {
+			return fmt.Errorf("error applying schema: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) applyMySQLIndexUpgrades() error {
+	query := `
+        SELECT COUNT(*)
+        FROM information_schema.STATISTICS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?
+    `
+	for _, upgrade := range mysqlIndexUpgrades {
+		var count int
+		if err := s.db.QueryRow(query, upgrade.table, upgrade.index).Scan(&count); err != nil {
+			return fmt.Errorf("error inspecting index %s.%s: %v", upgrade.table, upgrade.index, err)
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s %s", upgrade.table, upgrade.definition)); err != nil {
+			return fmt.Errorf("error adding index %s.%s: %v", upgrade.table, upgrade.index, err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) applySQLiteDataUpgrades() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+
+	for i := version; i < len(sqliteDataUpgrades); i++ {
+		if _, err := s.db.Exec(sqliteDataUpgrades[i]); err != nil {
+			return fmt.Errorf("error applying data upgrade %d: %v", i+1, err)
+		}
+		if _, err := s.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			return fmt.Errorf("error updating schema version: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *sqlStore) columnExists(table, column string) (bool, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+    `
+	if s.dialect == dialectSQLite {
+		query = `SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`
+	}
+
+	var count int
+	if err := s.db.QueryRow(query, table, column).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
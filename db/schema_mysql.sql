@@ -0,0 +1,164 @@
+CREATE TABLE IF NOT EXISTS messages
+(
+    id                  INT AUTO_INCREMENT PRIMARY KEY,
+    message_id          INT      NOT NULL,
+    chat_id             BIGINT   NOT NULL,
+    user_id             BIGINT   NOT NULL,
+    text                TEXT,
+    aggregated_text     TEXT,
+    date                DATETIME NOT NULL,
+    reply_to_message_id INT      NULL,
+    trigger_message_id  INT      NULL,
+    model               VARCHAR(64) NULL,
+    edited_at           DATETIME NULL,
+    media_type          VARCHAR(16) NULL,
+    INDEX idx_date (date),
+    INDEX idx_message_id (message_id),
+    INDEX idx_chat_message (chat_id, message_id),
+    FULLTEXT INDEX ft_text (text)
+);
+
+CREATE TABLE IF NOT EXISTS prompts
+(
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    type TINYINT UNSIGNED NOT NULL,
+    prompt TEXT,
+    author VARCHAR(64) NULL,
+    date DATETIME DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_type_id (type, id)
+);
+
+CREATE TABLE IF NOT EXISTS message_edits
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    chat_id    BIGINT   NOT NULL,
+    message_id INT      NOT NULL,
+    old_text   TEXT,
+    edited_at  DATETIME NOT NULL,
+    INDEX idx_chat_message (chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS retention_policies
+(
+    chat_id      BIGINT PRIMARY KEY,
+    max_age_days INT NOT NULL DEFAULT 0,
+    max_rows     INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS users
+(
+    user_id       BIGINT PRIMARY KEY,
+    username      VARCHAR(64)  NOT NULL DEFAULT '',
+    first_name    VARCHAR(255) NOT NULL DEFAULT '',
+    last_name     VARCHAR(255) NOT NULL DEFAULT '',
+    language_code VARCHAR(16)  NOT NULL DEFAULT '',
+    is_bot        BOOLEAN      NOT NULL DEFAULT FALSE,
+    updated_at    DATETIME     NOT NULL,
+    INDEX idx_username (username)
+);
+
+CREATE TABLE IF NOT EXISTS user_name_history
+(
+    id         INT AUTO_INCREMENT PRIMARY KEY,
+    user_id    BIGINT       NOT NULL,
+    username   VARCHAR(64)  NOT NULL DEFAULT '',
+    first_name VARCHAR(255) NOT NULL DEFAULT '',
+    last_name  VARCHAR(255) NOT NULL DEFAULT '',
+    changed_at DATETIME     NOT NULL,
+    INDEX idx_user_id (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_settings
+(
+    chat_id        BIGINT PRIMARY KEY,
+    model          VARCHAR(64) NOT NULL DEFAULT '',
+    reasoning      VARCHAR(16) NOT NULL DEFAULT '',
+    verbosity      VARCHAR(16) NOT NULL DEFAULT '',
+    history_limit  INT         NOT NULL DEFAULT 0,
+    search_mode    VARCHAR(16) NOT NULL DEFAULT '',
+    updated_by     BIGINT      NOT NULL DEFAULT 0,
+    updated_at     DATETIME    NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_counters
+(
+    day        CHAR(10)    NOT NULL,
+    scope      VARCHAR(8)  NOT NULL,
+    subject_id BIGINT      NOT NULL,
+    kind       VARCHAR(16) NOT NULL,
+    count      INT         NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, scope, subject_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_exemptions
+(
+    user_id    BIGINT PRIMARY KEY,
+    added_by   BIGINT   NOT NULL DEFAULT 0,
+    added_at   DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS allowed_chats
+(
+    chat_id    BIGINT PRIMARY KEY,
+    title      VARCHAR(255) NOT NULL DEFAULT '',
+    status     VARCHAR(16)  NOT NULL,
+    decided_by BIGINT       NOT NULL DEFAULT 0,
+    updated_at DATETIME     NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bot_requests
+(
+    chat_id            BIGINT      NOT NULL,
+    reply_message_id   INT         NOT NULL,
+    trigger_message_id INT         NOT NULL,
+    user_id            BIGINT      NOT NULL,
+    model              VARCHAR(64) NOT NULL,
+    question           TEXT        NOT NULL,
+    media              TEXT        NOT NULL,
+    history_limit      INT         NOT NULL DEFAULT 0,
+    reasoning          VARCHAR(16) NOT NULL DEFAULT '',
+    verbosity          VARCHAR(16) NOT NULL DEFAULT '',
+    created_at         DATETIME    NOT NULL,
+    PRIMARY KEY (chat_id, reply_message_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_resets
+(
+    chat_id          BIGINT   NOT NULL PRIMARY KEY,
+    after_message_id INT      NOT NULL,
+    reset_by         BIGINT   NOT NULL DEFAULT 0,
+    reset_at         DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedule_runs
+(
+    job        VARCHAR(64) NOT NULL,
+    chat_id    BIGINT      NOT NULL,
+    last_slot  DATETIME    NOT NULL,
+    updated_at DATETIME    NOT NULL,
+    PRIMARY KEY (job, chat_id)
+);
+
+CREATE TABLE IF NOT EXISTS reminders
+(
+    id         BIGINT AUTO_INCREMENT PRIMARY KEY,
+    chat_id    BIGINT       NOT NULL,
+    user_id    BIGINT       NOT NULL,
+    message_id INT          NOT NULL,
+    text       TEXT         NOT NULL,
+    due_at     DATETIME     NOT NULL,
+    recurrence VARCHAR(64)  NOT NULL DEFAULT '',
+    timezone   VARCHAR(64)  NOT NULL DEFAULT '',
+    created_at DATETIME     NOT NULL,
+    INDEX idx_reminders_due (due_at),
+    INDEX idx_reminders_chat_user (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS message_parts
+(
+    chat_id         BIGINT NOT NULL,
+    message_id      INT    NOT NULL,
+    position        INT    NOT NULL,
+    part_message_id INT    NOT NULL,
+    PRIMARY KEY (chat_id, message_id, position)
+);
@@ -0,0 +1,185 @@
+CREATE TABLE IF NOT EXISTS messages
+(
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id          INTEGER  NOT NULL,
+    chat_id             INTEGER  NOT NULL,
+    user_id             INTEGER  NOT NULL,
+    text                TEXT,
+    aggregated_text     TEXT,
+    date                DATETIME NOT NULL,
+    reply_to_message_id INTEGER  NULL,
+    trigger_message_id  INTEGER  NULL,
+    model               TEXT     NULL,
+    edited_at           DATETIME NULL,
+    media_type          TEXT     NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_date ON messages (date);
+CREATE INDEX IF NOT EXISTS idx_message_id ON messages (message_id);
+CREATE INDEX IF NOT EXISTS idx_chat_message ON messages (chat_id, message_id);
+
+-- Full-text index over messages.text, kept in sync by triggers.
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='id');
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+    INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+    INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+    INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+END;
+
+CREATE TABLE IF NOT EXISTS prompts
+(
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    type   INTEGER NOT NULL,
+    prompt TEXT,
+    author TEXT NULL,
+    date   DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_type_id ON prompts (type, id);
+
+CREATE TABLE IF NOT EXISTS message_edits
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id    INTEGER  NOT NULL,
+    message_id INTEGER  NOT NULL,
+    old_text   TEXT,
+    edited_at  DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_edits_chat_message ON message_edits (chat_id, message_id);
+
+CREATE TABLE IF NOT EXISTS retention_policies
+(
+    chat_id      INTEGER PRIMARY KEY,
+    max_age_days INTEGER NOT NULL DEFAULT 0,
+    max_rows     INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS users
+(
+    user_id       INTEGER PRIMARY KEY,
+    username      TEXT     NOT NULL DEFAULT '',
+    first_name    TEXT     NOT NULL DEFAULT '',
+    last_name     TEXT     NOT NULL DEFAULT '',
+    language_code TEXT     NOT NULL DEFAULT '',
+    is_bot        BOOLEAN  NOT NULL DEFAULT FALSE,
+    updated_at    DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_users_username ON users (username COLLATE NOCASE);
+
+CREATE TABLE IF NOT EXISTS user_name_history
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id    INTEGER  NOT NULL,
+    username   TEXT     NOT NULL DEFAULT '',
+    first_name TEXT     NOT NULL DEFAULT '',
+    last_name  TEXT     NOT NULL DEFAULT '',
+    changed_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_name_history_user ON user_name_history (user_id);
+
+CREATE TABLE IF NOT EXISTS chat_settings
+(
+    chat_id        INTEGER PRIMARY KEY,
+    model          TEXT    NOT NULL DEFAULT '',
+    reasoning      TEXT    NOT NULL DEFAULT '',
+    verbosity      TEXT    NOT NULL DEFAULT '',
+    history_limit  INTEGER NOT NULL DEFAULT 0,
+    search_mode    TEXT    NOT NULL DEFAULT '',
+    updated_by     INTEGER NOT NULL DEFAULT 0,
+    updated_at     DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_counters
+(
+    day        TEXT    NOT NULL,
+    scope      TEXT    NOT NULL,
+    subject_id INTEGER NOT NULL,
+    kind       TEXT    NOT NULL,
+    count      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, scope, subject_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_exemptions
+(
+    user_id    INTEGER PRIMARY KEY,
+    added_by   INTEGER  NOT NULL DEFAULT 0,
+    added_at   DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS allowed_chats
+(
+    chat_id    INTEGER PRIMARY KEY,
+    title      TEXT     NOT NULL DEFAULT '',
+    status     TEXT     NOT NULL,
+    decided_by INTEGER  NOT NULL DEFAULT 0,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bot_requests
+(
+    chat_id            INTEGER  NOT NULL,
+    reply_message_id   INTEGER  NOT NULL,
+    trigger_message_id INTEGER  NOT NULL,
+    user_id            INTEGER  NOT NULL,
+    model              TEXT     NOT NULL,
+    question           TEXT     NOT NULL,
+    media              TEXT     NOT NULL DEFAULT '',
+    history_limit      INTEGER  NOT NULL DEFAULT 0,
+    reasoning          TEXT     NOT NULL DEFAULT '',
+    verbosity          TEXT     NOT NULL DEFAULT '',
+    created_at         DATETIME NOT NULL,
+    PRIMARY KEY (chat_id, reply_message_id)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_resets
+(
+    chat_id          INTEGER  PRIMARY KEY,
+    after_message_id INTEGER  NOT NULL,
+    reset_by         INTEGER  NOT NULL DEFAULT 0,
+    reset_at         DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS schedule_runs
+(
+    job        TEXT     NOT NULL,
+    chat_id    INTEGER  NOT NULL,
+    last_slot  DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (job, chat_id)
+);
+
+CREATE TABLE IF NOT EXISTS reminders
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id    INTEGER  NOT NULL,
+    user_id    INTEGER  NOT NULL,
+    message_id INTEGER  NOT NULL,
+    text       TEXT     NOT NULL,
+    due_at     DATETIME NOT NULL,
+    recurrence TEXT     NOT NULL DEFAULT '',
+    timezone   TEXT     NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_reminders_due ON reminders (due_at);
+CREATE INDEX IF NOT EXISTS idx_reminders_chat_user ON reminders (chat_id, user_id);
+
+CREATE TABLE IF NOT EXISTS message_parts
+(
+    chat_id         INTEGER NOT NULL,
+    message_id      INTEGER NOT NULL,
+    position        INTEGER NOT NULL,
+    part_message_id INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, message_id, position)
+);
@@ -0,0 +1,451 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlStore implements Store on top of database/sql. MySQL and SQLite share
+// the same queries; dialect only matters where their SQL differs.
+type sqlStore struct {
+	db      *sql.DB
+	dialect string
+}
+
+const (
+	dialectMySQL  = "mysql"
+	dialectSQLite = "sqlite"
+)
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// upsertClause returns the dialect-specific tail of an INSERT that updates
+// cols when a row with the same keys already exists.
+func (s *sqlStore) upsertClause(keys []string, cols []string) string {
+	assignments := make([]string, len(cols))
+	if s.dialect == dialectSQLite {
+		for i, col := range cols {
+			assignments[i] = col + " = excluded." + col
+		}
+		return " ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(assignments, ", ")
+	}
+
+	for i, col := range cols {
+		assignments[i] = col + " = VALUES(" + col + ")"
+	}
+	return " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+}
+
+// messageColumns is the column list understood by scanMessage.
+const messageColumns = "id, message_id, chat_id, user_id, text, aggregated_text, date, reply_to_message_id, trigger_message_id, model, edited_at, media_type"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	var msg Message
+	var aggregated, model, mediaType sql.NullString
+	var replyTo, trigger sql.NullInt64
+	var editedAt sql.NullTime
+	if err := row.Scan(&msg.ID, &msg.MessageID, &msg.ChatID, &msg.UserID, &msg.Text, &aggregated, &msg.Date, &replyTo, &trigger, &model, &editedAt, &mediaType); err != nil {
+		return msg, err
+	}
+	if aggregated.Valid {
+		msg.AggregatedText = &aggregated.String
+	}
+	msg.ReplyToMessageID = int(replyTo.Int64)
+	msg.TriggerMessageID = int(trigger.Int64)
+	msg.Model = model.String
+	msg.EditedAt = editedAt.Time
+	msg.MediaType = mediaType.String
+	return msg, nil
+}
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error scanning row: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Error with rows: %v", err)
+	}
+
+	return messages, nil
+}
+
+// nullInt maps the zero value to NULL for optional integer columns.
+func nullInt(v int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(v), Valid: v != 0}
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
+
+const insertMessageSQL = `
+        INSERT INTO messages (message_id, chat_id, user_id, text, aggregated_text, date,
+                              reply_to_message_id, trigger_message_id, model, media_type, edited_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `
+
+func insertMessageArgs(msg Message) []any {
+	var editedAt sql.NullTime
+	if !msg.EditedAt.IsZero() {
+		editedAt = sql.NullTime{Time: msg.EditedAt.UTC(), Valid: true}
+	}
+	return []any{msg.MessageID, msg.ChatID, msg.UserID, msg.Text, msg.AggregatedText, msg.Date.UTC(),
+		nullInt(msg.ReplyToMessageID), nullInt(msg.TriggerMessageID), nullString(msg.Model), nullString(msg.MediaType), editedAt}
+}
+
+func (s *sqlStore) SaveMessage(msg Message) error {
+	_, err := s.db.Exec(insertMessageSQL, insertMessageArgs(msg)...)
+	return err
+}
+
+func (s *sqlStore) SaveMessages(msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	head, row, _ := strings.Cut(insertMessageSQL, "VALUES")
+	row = strings.TrimSpace(row)
+	rows := make([]string, len(msgs))
+	args := make([]any, 0, len(msgs)*11)
+	for i, msg := range msgs {
+		rows[i] = row
+		args = append(args, insertMessageArgs(msg)...)
+	}
+	_, err := s.db.Exec(head+"VALUES "+strings.Join(rows, ", "), args...)
+	return err
+}
+
+func (s *sqlStore) SetAggregatedText(chatID int64, messageID int, aggregatedText string) error {
+	query := `
+        UPDATE messages
+        SET aggregated_text = ?
+        WHERE chat_id = ? AND message_id = ?
+    `
+	_, err := s.db.Exec(query, aggregatedText, chatID, messageID)
+	return err
+}
+
+func (s *sqlStore) ListMessages(chatID int64, afterID int64, limit int) ([]Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE chat_id = ? AND id > ?
+        ORDER BY id
+        LIMIT ?
+    `
+	rows, err := s.db.Query(query, chatID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying messages: %v", err)
+	}
+	return scanMessages(rows)
+}
+
+func (s *sqlStore) ListMessageChatIDs() ([]int64, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT chat_id FROM messages ORDER BY chat_id`)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying chats: %v", err)
+	}
+	defer rows.Close()
+
+	var chatIDs []int64
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	return chatIDs, rows.Err()
+}
+
+func (s *sqlStore) ImportMessages(msgs []Message) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	inserted := 0
+	for _, msg := range msgs {
+		var exists int
+		err := tx.QueryRow(`SELECT COUNT(*) FROM messages WHERE chat_id = ? AND message_id = ?`, msg.ChatID, msg.MessageID).Scan(&exists)
+		if err != nil {
+			return 0, err
+		}
+		if exists > 0 {
+			continue
+		}
+		if _, err := tx.Exec(insertMessageSQL, insertMessageArgs(msg)...); err != nil {
+			return 0, fmt.Errorf("error importing message %d: %v", msg.MessageID, err)
+		}
+		inserted++
+	}
+
+	return inserted, tx.Commit()
+}
+
+func (s *sqlStore) GetLastMessages(chatID int64, limit int) ([]Message, error) {
+	query := `
+        SELECT *
+        FROM (
+            SELECT ` + messageColumns + `
+            FROM messages
+            WHERE chat_id = ?
+            ORDER BY date DESC
+            LIMIT ?
+        ) sub
+        ORDER BY message_id
+    `
+
+	rows, err := s.db.Query(query, chatID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying messages: %v", err)
+	}
+	return scanMessages(rows)
+}
+
+func (s *sqlStore) GetMessage(chatID int64, messageID int) (Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE chat_id = ? AND message_id = ?
+        ORDER BY id DESC
+        LIMIT 1
+    `
+
+	msg, err := scanMessage(s.db.QueryRow(query, chatID, messageID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return msg, ErrNotFound
+		}
+		return msg, fmt.Errorf("error scanning row: %v", err)
+	}
+	return msg, nil
+}
+
+func (s *sqlStore) EditMessage(chatID int64, messageID int, text string, aggregatedText *string, editedAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var oldText sql.NullString
+	query := `
+        SELECT id, text
+        FROM messages
+        WHERE chat_id = ? AND message_id = ?
+        ORDER BY id DESC
+        LIMIT 1
+    `
+	if err := tx.QueryRow(query, chatID, messageID).Scan(&id, &oldText); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	query = `
+        INSERT INTO message_edits (chat_id, message_id, old_text, edited_at)
+        VALUES (?, ?, ?, ?)
+    `
+	if _, err := tx.Exec(query, chatID, messageID, oldText, editedAt.UTC()); err != nil {
+		return err
+	}
+
+	query = `
+        UPDATE messages
+        SET text = ?, aggregated_text = ?, edited_at = ?
+        WHERE id = ?
+    `
+	if _, err := tx.Exec(query, text, aggregatedText, editedAt.UTC(), id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetBotReply(chatID int64, triggerMessageID int) (Message, error) {
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE chat_id = ? AND trigger_message_id = ?
+        ORDER BY id DESC
+        LIMIT 1
+    `
+
+	msg, err := scanMessage(s.db.QueryRow(query, chatID, triggerMessageID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return msg, ErrNotFound
+		}
+		return msg, fmt.Errorf("error scanning row: %v", err)
+	}
+	return msg, nil
+}
+
+func (s *sqlStore) SearchMessages(q SearchQuery) ([]Message, int, error) {
+	where := []string{"chat_id = ?"}
+	args := []any{q.ChatID}
+
+	if terms := strings.Fields(q.Text); len(terms) > 0 {
+		if s.dialect == dialectSQLite {
+			where = append(where, "id IN (SELECT rowid FROM messages_fts WHERE messages_fts MATCH ?)")
+			args = append(args, sqliteMatchExpr(terms))
+		} else {
+			where = append(where, "MATCH(text) AGAINST (? IN BOOLEAN MODE)")
+			args = append(args, mysqlMatchExpr(terms))
+		}
+	}
+	if q.UserID != 0 {
+		where = append(where, "user_id = ?")
+		args = append(args, q.UserID)
+	}
+	if !q.After.IsZero() {
+		where = append(where, "date >= ?")
+		args = append(args, q.After.UTC())
+	}
+	if !q.Before.IsZero() {
+		where = append(where, "date < ?")
+		args = append(args, q.Before.UTC())
+	}
+	if q.MediaType == "link" {
+		where = append(where, "(text LIKE '%http://%' OR text LIKE '%https://%' OR text LIKE '%www.%')")
+	} else if q.MediaType != "" {
+		where = append(where, "media_type = ?")
+		args = append(args, q.MediaType)
+	}
+	whereSQL := strings.Join(where, " AND ")
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM messages WHERE "+whereSQL, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting search results: %v", err)
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	query := `
+        SELECT ` + messageColumns + `
+        FROM messages
+        WHERE ` + whereSQL + `
+        ORDER BY date DESC, id DESC
+        LIMIT ? OFFSET ?
+    `
+	rows, err := s.db.Query(query, append(args, q.Limit, q.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching messages: %v", err)
+	}
+	messages, err := scanMessages(rows)
+	return messages, total, err
+}
+
+// sqliteMatchExpr quotes every term so FTS5 operators in user input are
+// treated as plain text; all terms must match.
+func sqliteMatchExpr(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// mysqlMatchExpr requires every term in boolean mode and strips characters
+// that have a meaning there.
+func mysqlMatchExpr(terms []string) string {
+	strip := strings.NewReplacer("+", "", "-", " ", "<", "", ">", "", "(", "", ")", "", "~", "", "*", "", `"`, "", "@", "")
+	var parts []string
+	for _, term := range terms {
+		for _, word := range strings.Fields(strip.Replace(term)) {
+			parts = append(parts, "+"+word)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (s *sqlStore) GetLatestPrompt(promptType int) (string, error) {
+	query := `
+        SELECT prompt
+        FROM prompts
+        WHERE type = ?
+        ORDER BY id DESC
+        LIMIT 1
+    `
+
+	var promptText string
+	err := s.db.QueryRow(query, promptType).Scan(&promptText)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("error scanning row: %v", err)
+	}
+	return promptText, nil
+}
+
+func (s *sqlStore) InsertPrompt(promptText string, promptType int, author string) error {
+	query := `
+        INSERT INTO prompts (type, prompt, author, date)
+        VALUES (?, ?, ?, ?)
+    `
+	_, err := s.db.Exec(query, promptType, promptText, nullString(author), time.Now().UTC())
+	return err
+}
+
+const promptColumns = "id, type, prompt, author, date"
+
+func scanPrompt(row rowScanner) (Prompt, error) {
+	var p Prompt
+	var text, author sql.NullString
+	var date sql.NullTime
+	if err := row.Scan(&p.ID, &p.Type, &text, &author, &date); err != nil {
+		return p, err
+	}
+	p.Text = text.String
+	p.Author = author.String
+	p.Date = date.Time
+	return p, nil
+}
+
+func (s *sqlStore) ListPrompts(promptType int) ([]Prompt, error) {
+	rows, err := s.db.Query(`SELECT `+promptColumns+` FROM prompts WHERE type = ? ORDER BY id DESC`, promptType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prompts []Prompt
+	for rows.Next() {
+		p, err := scanPrompt(rows)
+		if err != nil {
+			return nil, err
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts, rows.Err()
+}
+
+func (s *sqlStore) GetPrompt(id int) (Prompt, error) {
+	p, err := scanPrompt(s.db.QueryRow(`SELECT `+promptColumns+` FROM prompts WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	return p, err
+}
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens (or creates) an SQLite database file and applies the schema.
+func openSQLite(path string) (Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("sqlite DSN must contain a file path")
+	}
+
+	conn, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	// SQLite allows a single writer; serialising connections avoids SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+
+	s := &sqlStore{db: conn, dialect: dialectSQLite}
+	if err := s.migrate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
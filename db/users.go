@@ -0,0 +1,162 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// User is the last known profile of a Telegram user.
+type User struct {
+	UserID       int64
+	Username     string
+	FirstName    string
+	LastName     string
+	LanguageCode string
+	IsBot        bool
+	UpdatedAt    time.Time
+}
+
+// SameName reports whether both profiles have the same username and names.
+func (u User) SameName(other User) bool {
+	return u.Username == other.Username && u.FirstName == other.FirstName && u.LastName == other.LastName
+}
+
+func (u User) hasName() bool {
+	return u.Username != "" || u.FirstName != "" || u.LastName != ""
+}
+
+type UserStore interface {
+	// UpsertUser stores the profile. When the username or name changed, the
+	// previous values are kept in user_name_history.
+	UpsertUser(u User) error
+	// GetUser returns a stored profile or ErrNotFound.
+	GetUser(userID int64) (User, error)
+	// FindUserByUsername looks a user up by username, case-insensitively and
+	// with or without the leading "@". It returns ErrNotFound when unknown.
+	FindUserByUsername(username string) (User, error)
+}
+
+func UpsertUser(u User) error {
+	return store.UpsertUser(u)
+}
+
+func GetUser(userID int64) (User, error) {
+	return store.GetUser(userID)
+}
+
+func FindUserByUsername(username string) (User, error) {
+	// Users without a username are stored with an empty one, which must not
+	// match a bare "@".
+	if strings.TrimPrefix(strings.TrimSpace(username), "@") == "" {
+		return User{}, ErrNotFound
+	}
+	return store.FindUserByUsername(strings.TrimSpace(username))
+}
+
+const userColumns = "user_id, username, first_name, last_name, language_code, is_bot, updated_at"
+
+func scanUser(row rowScanner) (User, error) {
+	var u User
+	err := row.Scan(&u.UserID, &u.Username, &u.FirstName, &u.LastName, &u.LanguageCode, &u.IsBot, &u.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, ErrNotFound
+	}
+	return u, err
+}
+
+func (s *sqlStore) UpsertUser(u User) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = time.Now()
+	}
+
+	old, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_id = ?`, u.UserID))
+	switch {
+	case errors.Is(err, ErrNotFound):
+		query := `INSERT INTO users (` + userColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?)`
+		if _, err := tx.Exec(query, u.UserID, u.Username, u.FirstName, u.LastName, u.LanguageCode, u.IsBot, u.UpdatedAt.UTC()); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		if !old.SameName(u) && old.hasName() {
+			query := `
+                INSERT INTO user_name_history (user_id, username, first_name, last_name, changed_at)
+                VALUES (?, ?, ?, ?, ?)
+            `
+			if _, err := tx.Exec(query, old.UserID, old.Username, old.FirstName, old.LastName, u.UpdatedAt.UTC()); err != nil {
+				return err
+			}
+		}
+		query := `
+            UPDATE users
+            SET username = ?, first_name = ?, last_name = ?, language_code = ?, is_bot = ?, updated_at = ?
+            WHERE user_id = ?
+        `
+		if _, err := tx.Exec(query, u.Username, u.FirstName, u.LastName, u.LanguageCode, u.IsBot, u.UpdatedAt.UTC(), u.UserID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqlStore) GetUser(userID int64) (User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE user_id = ?`, userID))
+}
+
+func (s *sqlStore) FindUserByUsername(username string) (User, error) {
+	username = strings.TrimPrefix(username, "@")
+	query := `SELECT ` + userColumns + ` FROM users WHERE LOWER(username) = LOWER(?) ORDER BY updated_at DESC LIMIT 1`
+	return scanUser(s.db.QueryRow(query, username))
+}
+
+func (m *memoryStore) UpsertUser(u User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u.UpdatedAt.IsZero() {
+		u.UpdatedAt = time.Now()
+	}
+	if m.users == nil {
+		m.users = make(map[int64]User)
+	}
+	if old, ok := m.users[u.UserID]; ok && !old.SameName(u) && old.hasName() {
+		old.UpdatedAt = u.UpdatedAt
+		m.nameHistory = append(m.nameHistory, old)
+	}
+	m.users[u.UserID] = u
+	return nil
+}
+
+func (m *memoryStore) GetUser(userID int64) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *memoryStore) FindUserByUsername(username string) (User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	username = strings.TrimPrefix(username, "@")
+	for _, u := range m.users {
+		if u.Username != "" && strings.EqualFold(u.Username, username) {
+			return u, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
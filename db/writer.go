@@ -0,0 +1,155 @@
+package db
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// messageWriter persists queued messages in the background, batching them
+// into multi-row inserts.
+type messageWriter struct {
+	queue         chan writeRequest
+	batchSize     int
+	flushInterval time.Duration
+	pending       atomic.Int64
+	done          chan struct{}
+}
+
+// writeRequest carries either a message to store or, when flushed is set, a
+// request to write everything queued before it.
+type writeRequest struct {
+	msg     Message
+	flushed chan struct{}
+}
+
+var (
+	writer     *messageWriter
+	writerLock sync.RWMutex
+)
+
+// StartMessageWriter makes QueueMessage asynchronous. Up to bufferSize
+// messages are held in memory; once the buffer is full QueueMessage blocks
+// until the writer catches up. Batches are written when batchSize messages
+// are pending or flushInterval has passed.
+func StartMessageWriter(bufferSize, batchSize int, flushInterval time.Duration) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	w := &messageWriter{
+		queue:         make(chan writeRequest, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+
+	writerLock.Lock()
+	writer = w
+	writerLock.Unlock()
+
+	go w.run()
+	log.Printf("Message writer started (buffer %d, batch %d, flush every %s)", bufferSize, batchSize, flushInterval)
+}
+
+// StopMessageWriter writes all queued messages and stops the writer. Later
+// QueueMessage calls save synchronously.
+func StopMessageWriter() {
+	writerLock.Lock()
+	w := writer
+	writer = nil
+	writerLock.Unlock()
+
+	if w == nil {
+		return
+	}
+	close(w.queue)
+	<-w.done
+}
+
+// QueueMessage stores msg through the background writer, or directly when no
+// writer is running.
+func QueueMessage(msg Message) {
+	writerLock.RLock()
+	defer writerLock.RUnlock()
+
+	if writer == nil {
+		_ = SaveMessage(msg)
+		return
+	}
+
+	writer.pending.Add(1)
+	select {
+	case writer.queue <- writeRequest{msg: msg}:
+	default:
+		log.Printf("Message write queue is full (%d), waiting for the database", cap(writer.queue))
+		writer.queue <- writeRequest{msg: msg}
+	}
+}
+
+// FlushMessages blocks until every message queued so far has been written.
+// Reads call it first so they always see the bot's own recent writes.
+func FlushMessages() {
+	writerLock.RLock()
+	defer writerLock.RUnlock()
+
+	if writer == nil || writer.pending.Load() == 0 {
+		return
+	}
+	flushed := make(chan struct{})
+	writer.queue <- writeRequest{flushed: flushed}
+	<-flushed
+}
+
+func (w *messageWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	var batch []Message
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		w.save(batch)
+		w.pending.Add(-int64(len(batch)))
+		batch = nil
+	}
+
+	for {
+		select {
+		case req, ok := <-w.queue:
+			if !ok {
+				write()
+				return
+			}
+			if req.flushed != nil {
+				write()
+				close(req.flushed)
+				continue
+			}
+			batch = append(batch, req.msg)
+			if len(batch) >= w.batchSize {
+				write()
+			}
+		case <-ticker.C:
+			write()
+		}
+	}
+}
+
+// save writes one batch. If the multi-row insert fails the messages are
+// retried one by one so a single bad row does not drop the whole batch.
+func (w *messageWriter) save(batch []Message) {
+	err := store.SaveMessages(batch)
+	if err == nil {
+		return
+	}
+	log.Printf("Error saving batch of %d messages, retrying one by one: %v", len(batch), err)
+	for _, msg := range batch {
+		if err := store.SaveMessage(msg); err != nil {
+			log.Printf("Error saving message %d in chat %d: %v", msg.MessageID, msg.ChatID, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultDailyDigest  = "0 21 * * *"
+	defaultWeeklyDigest = "0 20 * * 0"
+	// digestCatchUp is how late a digest missed during downtime is still
+	// posted.
+	digestCatchUp = 12 * time.Hour
+)
+
+// digestMinMessages is the activity below which a digest is not posted.
+// Read from DIGEST_MIN_MESSAGES.
+var digestMinMessages = 20
+
+// initDigests schedules the daily and weekly digests of the allowed group
+// chats. DIGEST_DAILY and DIGEST_WEEKLY are cron expressions in
+// DIGEST_TIMEZONE (default: TIMEZONE); "off" disables one.
+func initDigests() {
+	location := getLocationFromEnv("DIGEST_TIMEZONE", botLocation)
+	digestMinMessages = getOptionalIntFromEnv("DIGEST_MIN_MESSAGES", digestMinMessages)
+
+	digests := []struct {
+		env, def, name, title string
+		days                  int
+	}{
+		{"DIGEST_DAILY", defaultDailyDigest, "daily_digest", "Daily digest", 1},
+		{"DIGEST_WEEKLY", defaultWeeklyDigest, "weekly_digest", "Weekly roundup", 7},
+	}
+	for _, d := range digests {
+		expr := os.Getenv(d.env)
+		if expr == "" {
+			expr = d.def
+		}
+		if strings.EqualFold(expr, "off") {
+			continue
+		}
+		schedule, err := parseCron(expr, location)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", d.env, err)
+		}
+
+		title, days := d.title, d.days
+		scheduledJobs = append(scheduledJobs, &scheduledJob{
+			Name:     d.name,
+			Schedule: schedule,
+			CatchUp:  digestCatchUp,
+			Chats:    digestChatIDs,
+			Run: func(chatID int64, slot time.Time) error {
+				return postDigest(chatID, title, slot.AddDate(0, 0, -days), slot)
+			},
+		})
+		fmt.Printf("%s: %s (%s)\n", title, expr, location)
+	}
+}
+
+// digestChatIDs returns the allowed group chats.
+func digestChatIDs() []int64 {
+	var chatIDs []int64
+	for _, chatID := range allowedChatIDs() {
+		if chatID < 0 {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	return chatIDs
+}
+
+// postDigest posts a digest of the messages from since until until, unless
+// there were fewer than digestMinMessages of them.
+func postDigest(chatID int64, title string, since time.Time, until time.Time) error {
+	r := summaryRange{Since: since, Until: until}
+	messages, err := loadSummaryRange(chatID, r)
+	if err != nil {
+		return fmt.Errorf("error loading messages: %v", err)
+	}
+	if len(messages) < digestMinMessages {
+		log.Printf("%s of chat %d skipped: only %d messages", title, chatID, len(messages))
+		return nil
+	}
+
+	period := "the day"
+	if until.Sub(since) > 48*time.Hour {
+		period = "the week"
+	}
+	settings := effectiveChatSettings(chatID, mentionDefaults())
+	digest, err := summarizeMessages(chatID, messages, period, "", settings.Model)
+	if err != nil {
+		return fmt.Errorf("error summarizing: %v", err)
+	}
+
+	header := fmt.Sprintf("**%s** for %s", title, digestPeriodLabel(since, until))
+	msg := tgbotapi.NewMessage(chatID, header+"\n\n"+digest)
+	msg.ParseMode = tgbotapi.ModeMarkdownV2
+	if sendBackgroundMessage(msg, true) == nil {
+		return fmt.Errorf("error sending digest")
+	}
+	return nil
+}
+
+// digestPeriodLabel names the day of a daily digest or the days of a longer
+// one.
+func digestPeriodLabel(since time.Time, until time.Time) string {
+	last := until.Add(-time.Minute)
+	if until.Sub(since) <= 48*time.Hour {
+		return last.Format("02.01.2006")
+	}
+	return since.Format("02.01") + " – " + last.Format("02.01.2006")
+}
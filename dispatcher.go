@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// deepQueueWarning is the per-chat backlog at which dispatch starts logging.
+const deepQueueWarning = 20
+
+// updateDispatcher runs updates of one chat strictly in arrival order while
+// different chats are handled in parallel. Every chat with pending updates
+// gets its own queue and goroutine; workers bounds how many updates run at
+// once and slots how many may wait in all queues together.
+type updateDispatcher struct {
+	mu     sync.Mutex
+	queues map[int64]*updateQueue
+	// stopped makes the queues drop what is still pending.
+	stopped   bool
+	abandoned int
+
+	slots    chan struct{}
+	workers  chan struct{}
+	running  sync.WaitGroup
+	inFlight atomic.Int64
+	handled  atomic.Int64
+	handle   func(tgbotapi.Update)
+}
+
+type updateQueue struct {
+	chatID  int64
+	pending []tgbotapi.Update
+}
+
+func newUpdateDispatcher(workerCount, maxQueued int, handle func(tgbotapi.Update)) *updateDispatcher {
+	return &updateDispatcher{
+		queues:  make(map[int64]*updateQueue),
+		slots:   make(chan struct{}, maxQueued),
+		workers: make(chan struct{}, workerCount),
+		handle:  handle,
+	}
+}
+
+// updateChatID is the ordering key of an update: the chat it belongs to, or
+// the user for updates without a chat.
+func updateChatID(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat.ID
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From.ID
+	case update.MyChatMember != nil:
+		return update.MyChatMember.Chat.ID
+	}
+	return 0
+}
+
+// dispatch queues update behind earlier updates of the same chat. It blocks
+// while the total backlog is full and gives up when done is closed.
+func (d *updateDispatcher) dispatch(update tgbotapi.Update, done <-chan struct{}) bool {
+	select {
+	case d.slots <- struct{}{}:
+	case <-done:
+		return false
+	}
+
+	chatID := updateChatID(update)
+	d.mu.Lock()
+	q, ok := d.queues[chatID]
+	if !ok {
+		q = &updateQueue{chatID: chatID}
+		d.queues[chatID] = q
+		d.running.Add(1)
+		go d.run(q)
+	}
+	q.pending = append(q.pending, update)
+	depth := len(q.pending)
+	d.mu.Unlock()
+
+	if depth >= deepQueueWarning && depth%deepQueueWarning == 0 {
+		log.Printf("Update queue of chat %d is %d deep", chatID, depth)
+	}
+	return true
+}
+
+// run handles the updates of one chat one at a time and exits once the
+// queue is empty; the next update of the chat starts a new goroutine.
+func (d *updateDispatcher) run(q *updateQueue) {
+	defer d.running.Done()
+
+	for {
+		d.mu.Lock()
+		if d.stopped || len(q.pending) == 0 {
+			d.dropLocked(q)
+			d.mu.Unlock()
+			return
+		}
+		update := q.pending[0]
+		q.pending = q.pending[1:]
+		d.mu.Unlock()
+
+		d.workers <- struct{}{}
+		d.inFlight.Add(1)
+		d.handle(update)
+		d.inFlight.Add(-1)
+		d.handled.Add(1)
+		<-d.workers
+		<-d.slots
+	}
+}
+
+// dropLocked removes q and counts whatever it still holds as abandoned.
+func (d *updateDispatcher) dropLocked(q *updateQueue) {
+	for range q.pending {
+		d.abandoned++
+		<-d.slots
+	}
+	q.pending = nil
+	delete(d.queues, q.chatID)
+}
+
+// wait lets the queues drain until deadline. After that pending updates are
+// dropped; updates already being handled keep running. It returns the number
+// of abandoned updates.
+func (d *updateDispatcher) wait(deadline time.Time) int {
+	if !waitTimeout(&d.running, deadline) {
+		log.Printf("Shutdown: grace period over, %d updates still in progress", d.inFlight.Load())
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopped = true
+	for _, q := range d.queues {
+		d.dropLocked(q)
+	}
+	return d.abandoned
+}
+
+// queueDepths returns the number of updates waiting per chat.
+func (d *updateDispatcher) queueDepths() map[int64]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	depths := make(map[int64]int, len(d.queues))
+	for chatID, q := range d.queues {
+		depths[chatID] = len(q.pending)
+	}
+	return depths
+}
+
+// metricsHandler reports queue depths in the Prometheus text format.
+func (d *updateDispatcher) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	depths := d.queueDepths()
+	chatIDs := make([]int64, 0, len(depths))
+	total, maxDepth := 0, 0
+	for chatID, depth := range depths {
+		chatIDs = append(chatIDs, chatID)
+		total += depth
+		maxDepth = max(maxDepth, depth)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP bot_update_queue_depth Updates waiting to be handled, per chat.")
+	fmt.Fprintln(w, "# TYPE bot_update_queue_depth gauge")
+	for _, chatID := range chatIDs {
+		fmt.Fprintf(w, "bot_update_queue_depth{chat_id=\"%s\"} %d\n", strconv.FormatInt(chatID, 10), depths[chatID])
+	}
+	fmt.Fprintln(w, "# HELP bot_update_queue_total Updates waiting to be handled in all chats.")
+	fmt.Fprintln(w, "# TYPE bot_update_queue_total gauge")
+	fmt.Fprintf(w, "bot_update_queue_total %d\n", total)
+	fmt.Fprintln(w, "# HELP bot_update_queue_max_depth Deepest per-chat queue.")
+	fmt.Fprintln(w, "# TYPE bot_update_queue_max_depth gauge")
+	fmt.Fprintf(w, "bot_update_queue_max_depth %d\n", maxDepth)
+	fmt.Fprintln(w, "# HELP bot_updates_in_progress Updates being handled right now.")
+	fmt.Fprintln(w, "# TYPE bot_updates_in_progress gauge")
+	fmt.Fprintf(w, "bot_updates_in_progress %d\n", d.inFlight.Load())
+	fmt.Fprintln(w, "# HELP bot_updates_handled_total Updates handled since start.")
+	fmt.Fprintln(w, "# TYPE bot_updates_handled_total counter")
+	fmt.Fprintf(w, "bot_updates_handled_total %d\n", d.handled.Load())
+
+	outgoing := outbox.queueDepths()
+	fmt.Fprintln(w, "# HELP bot_outgoing_queue_depth Messages waiting to be sent, per priority.")
+	fmt.Fprintln(w, "# TYPE bot_outgoing_queue_depth gauge")
+	fmt.Fprintf(w, "bot_outgoing_queue_depth{priority=\"reply\"} %d\n", outgoing[priorityReply])
+	fmt.Fprintf(w, "bot_outgoing_queue_depth{priority=\"background\"} %d\n", outgoing[priorityBackground])
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+// handleEditedMessage keeps the stored copy of an edited message in sync and,
+// if the message had been answered by the bot, regenerates that answer in place.
+func handleEditedMessage(message *tgbotapi.Message) {
+	text := message.Text
+	if text == "" {
+		text = message.Caption
+	}
+	if message.IsCommand() {
+		// Commands are stored by their arguments, see handleGptCommand.
+		text = message.CommandArguments()
+	}
+	if text == "" {
+		return
+	}
+
+	editedAt := time.Unix(int64(message.EditDate), 0)
+	err := db.EditMessage(message.Chat.ID, message.MessageID, text, nil, editedAt)
+	if errors.Is(err, db.ErrNotFound) {
+		log.Printf("Edited message %d was never stored, ignoring.", message.MessageID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating edited message %d: %v", message.MessageID, err)
+		return
+	}
+
+	reply, err := db.GetBotReply(message.Chat.ID, message.MessageID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error looking up bot reply for message %d: %v", message.MessageID, err)
+		}
+		return
+	}
+
+	replyToBotMessage := message.ReplyToMessage != nil && bot.Self.ID == message.ReplyToMessage.From.ID
+	if message.IsCommand() || (!isBotMentioned(text) && !replyToBotMessage) {
+		log.Printf("Edited message %d no longer addresses the bot, keeping reply %d.", message.MessageID, reply.MessageID)
+		return
+	}
+
+	regenerateReply(message, reply)
+}
+
+// regenerateReply answers message again and replaces the text of the earlier
+// bot reply instead of posting a new one.
+func regenerateReply(message *tgbotapi.Message, reply db.Message) {
+	stopTyping := keepChatAction(message.Chat, mentionChatAction(message))
+	answer, err := generateMentionAnswer(message)
+	stopTyping()
+	if err != nil {
+		log.Printf("Error regenerating reply %d: %v", reply.MessageID, err)
+		return
+	}
+
+	keyboard := answerKeyboard()
+	if err := editBotMessage(message.Chat.ID, reply.MessageID, answer.Text, &keyboard); err != nil {
+		log.Printf("Error editing reply %d: %v", reply.MessageID, err)
+		return
+	}
+	saveAnswerRequest(message, reply.MessageID, answer)
+
+	if err := db.EditMessage(message.Chat.ID, reply.MessageID, answer.Text, nil, time.Now()); err != nil {
+		log.Printf("Error updating regenerated reply %d: %v", reply.MessageID, err)
+		return
+	}
+	goBackground(func() { fillAggregatedText(message.Chat.ID, reply.MessageID, answer.Text) })
+}
+
+// editBotMessage replaces the text of a message previously sent by the bot,
+// using the same formatting as sendMessage. A nil markup removes the inline
+// keyboard. Texts over Telegram's limit keep their first part in the message
+// and continue in follow-up messages, or in a file, as sendMessage would send
+// them; the follow-up messages of the previous text are deleted.
+func editBotMessage(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
+	parts := splitMessageText(text, telegramTextLimit)
+	asFile := sendAsFile(text, parts)
+	first := parts[0]
+	if asFile {
+		first = answerFileCaption(text)
+	}
+	if err := editMessageText(chatID, messageID, first, markup); err != nil {
+		return err
+	}
+	replaceMessageParts(chatID, messageID, text, parts[1:], asFile)
+	return nil
+}
+
+func editMessageText(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, renderAnswerHTML(text))
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = markup
+
+	_, err := sendQueued(edit.ChatID, edit, priorityReply)
+	if err != nil && strings.Contains(err.Error(), "can't parse entities") {
+		log.Printf("HTML parse error: %v, retrying without parse_mode", err)
+		edit.ParseMode = ""
+		edit.Text = text
+		_, err = sendQueued(edit.ChatID, edit, priorityReply)
+	}
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// replaceMessageParts deletes the follow-up messages of an edited message and
+// sends the new ones as replies to it: the remaining parts, or the whole text
+// as a file.
+func replaceMessageParts(chatID int64, messageID int, text string, parts []string, asFile bool) {
+	old, err := db.GetMessageParts(chatID, messageID)
+	if err != nil {
+		log.Printf("Error loading parts of message %d: %v", messageID, err)
+	}
+	for _, partID := range old {
+		if err := requestQueued(chatID, tgbotapi.NewDeleteMessage(chatID, partID), priorityReply); err != nil {
+			log.Printf("Error deleting part %d of message %d: %v", partID, messageID, err)
+		}
+	}
+
+	var partIDs []int
+	if asFile {
+		msg := tgbotapi.NewMessage(chatID, text)
+		msg.ReplyToMessageID = messageID
+		if sent := sendAnswerFile(msg, priorityReply); sent != nil {
+			partIDs = append(partIDs, sent.MessageID)
+		}
+	} else {
+		for _, part := range parts {
+			msg := tgbotapi.NewMessage(chatID, part)
+			msg.ParseMode = tgbotapi.ModeMarkdownV2
+			msg.ReplyToMessageID = messageID
+			sent := sendMessagePart(msg, priorityReply)
+			if sent == nil {
+				break
+			}
+			partIDs = append(partIDs, sent.MessageID)
+		}
+	}
+
+	if len(old) == 0 && len(partIDs) == 0 {
+		return
+	}
+	if err := db.SetMessageParts(chatID, messageID, partIDs); err != nil {
+		log.Printf("Error saving parts of message %d: %v", messageID, err)
+	}
+}
@@ -5,12 +5,14 @@ import (
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
 )
 
 var bot *tgbotapi.BotAPI
+
+// dispatcher orders incoming updates per chat, see handleUpdates.
+var dispatcher *updateDispatcher
 var botUsername string
-var allowedChatID int64
-var testChatID int64
 var adminChatID int64
 
 var openAIToken string
@@ -19,9 +21,28 @@ var gptModelForGptCommand string
 var gptModelForWebSearch string
 var gptModelForRouting string
 
+// botLocation is the time zone of schedules and reminders that do not name
+// one. Read from TIMEZONE; defaults to the server's zone.
+var botLocation = time.Local
+
+// settingsModels are the models chat admins can pick in /settings.
+var settingsModels []string
+
+// knownUsers caches the users table so unchanged profiles are not written
+// on every message.
+var (
+	knownUsers     = make(map[int64]db.User)
+	knownUsersLock sync.RWMutex
+)
+
+// failedUserLookups holds when Telegram last failed to tell who a user is,
+// so the lookup is retried after failedUserLookupTTL and not for every
+// message in between.
+const failedUserLookupTTL = 1 * time.Hour
+
 var (
-	usernames     = make(map[int64]string)
-	usernamesLock sync.RWMutex
+	failedUserLookups     = make(map[int64]time.Time)
+	failedUserLookupsLock sync.Mutex
 )
 
 type mediaGroupEntry struct {
@@ -35,3 +56,17 @@ var (
 	mediaGroupCache     = make(map[string]*mediaGroupEntry)
 	mediaGroupCacheLock sync.Mutex
 )
+
+type searchSession struct {
+	query   db.SearchQuery
+	label   string
+	updated time.Time
+}
+
+const searchSessionTTL = 1 * time.Hour
+
+var (
+	searchSessions     = make(map[string]*searchSession)
+	searchSessionsLock sync.Mutex
+	searchSessionSeq   int64
+)
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"pet.outbid.goapp/db"
+)
+
+const (
+	exportFormatJSONL    = "jsonl"
+	exportFormatTelegram = "telegram"
+
+	importBatchSize = 500
+	exportPageSize  = 1000
+)
+
+// telegramExport is the subset of Telegram Desktop's result.json we use. It
+// is either a single chat export or a full account export with chats.list.
+type telegramExport struct {
+	telegramExportChat
+	Chats struct {
+		List []telegramExportChat `json:"list"`
+	} `json:"chats"`
+}
+
+type telegramExportChat struct {
+	Name     string                  `json:"name,omitempty"`
+	Type     string                  `json:"type"`
+	ID       int64                   `json:"id"`
+	Messages []telegramExportMessage `json:"messages"`
+}
+
+type telegramExportMessage struct {
+	ID               int                    `json:"id"`
+	Type             string                 `json:"type"`
+	Date             string                 `json:"date"`
+	DateUnixtime     string                 `json:"date_unixtime"`
+	Edited           string                 `json:"edited,omitempty"`
+	EditedUnixtime   string                 `json:"edited_unixtime,omitempty"`
+	From             string                 `json:"from,omitempty"`
+	FromID           string                 `json:"from_id,omitempty"`
+	ReplyToMessageID int                    `json:"reply_to_message_id,omitempty"`
+	Text             json.RawMessage        `json:"text"`
+	TextEntities     []telegramExportEntity `json:"text_entities"`
+	Photo            string                 `json:"photo,omitempty"`
+	File             string                 `json:"file,omitempty"`
+	MediaType        string                 `json:"media_type,omitempty"`
+	MimeType         string                 `json:"mime_type,omitempty"`
+}
+
+type telegramExportEntity struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Href string `json:"href,omitempty"`
+}
+
+// exportedMessage is one line of the JSONL export.
+type exportedMessage struct {
+	MessageID        int        `json:"message_id"`
+	ChatID           int64      `json:"chat_id"`
+	UserID           int64      `json:"user_id"`
+	Text             string     `json:"text"`
+	AggregatedText   *string    `json:"aggregated_text,omitempty"`
+	Date             time.Time  `json:"date"`
+	ReplyToMessageID int        `json:"reply_to_message_id,omitempty"`
+	TriggerMessageID int        `json:"trigger_message_id,omitempty"`
+	Model            string     `json:"model,omitempty"`
+	MediaType        string     `json:"media_type,omitempty"`
+	EditedAt         *time.Time `json:"edited_at,omitempty"`
+}
+
+type importResult struct {
+	ChatID   int64
+	Total    int
+	Imported int
+	Skipped  int
+}
+
+// telegramMediaTypes maps result.json media_type values to Message.MediaType.
+var telegramMediaTypes = map[string]string{
+	"animation":     "animation",
+	"video_file":    "video",
+	"video_message": "video_note",
+	"voice_message": "voice",
+	"audio_file":    "audio",
+	"sticker":       "sticker",
+}
+
+// importTelegramExport reads a Telegram Desktop result.json and stores its
+// messages. Messages already present are left untouched, so the same file
+// can be imported repeatedly. chatID overrides the chat ID from the export
+// when not zero; a full account export then must contain a single chat.
+func importTelegramExport(r io.Reader, chatID int64) ([]importResult, error) {
+	var export telegramExport
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, fmt.Errorf("error parsing export: %v", err)
+	}
+
+	chats := export.Chats.List
+	if len(export.Messages) > 0 || export.ID != 0 {
+		chats = append(chats, export.telegramExportChat)
+	}
+	if len(chats) == 0 {
+		return nil, fmt.Errorf("export contains no chats")
+	}
+	if chatID != 0 && len(chats) > 1 {
+		return nil, fmt.Errorf("export contains %d chats, a chat ID override needs a single-chat export", len(chats))
+	}
+
+	var results []importResult
+	for _, chat := range chats {
+		targetChatID := chatID
+		if targetChatID == 0 {
+			targetChatID = telegramExportChatID(chat)
+		}
+
+		result, err := importTelegramChat(chat, targetChatID)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func importTelegramChat(chat telegramExportChat, chatID int64) (importResult, error) {
+	result := importResult{ChatID: chatID}
+
+	batch := make([]db.Message, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		inserted, err := db.ImportMessages(batch)
+		if err != nil {
+			return err
+		}
+		result.Imported += inserted
+		result.Skipped += len(batch) - inserted
+		batch = batch[:0]
+		return nil
+	}
+
+	names := make(map[int64]string)
+	for _, exported := range chat.Messages {
+		if exported.Type != "message" {
+			continue
+		}
+		msg, ok := exportToMessage(exported, chatID)
+		if !ok {
+			continue
+		}
+		result.Total++
+		if exported.From != "" {
+			names[msg.UserID] = exported.From
+		}
+
+		batch = append(batch, msg)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return result, err
+	}
+
+	rememberImportedUsers(names)
+	return result, nil
+}
+
+// rememberImportedUsers stores export display names for users the bot has
+// never seen. Exports carry no usernames, so the name goes to FirstName.
+func rememberImportedUsers(names map[int64]string) {
+	for userID, name := range names {
+		if _, known := lookupUser(userID); known {
+			continue
+		}
+		if err := db.UpsertUser(db.User{UserID: userID, FirstName: name}); err != nil {
+			log.Printf("Error saving imported user %d: %v", userID, err)
+		}
+	}
+}
+
+func exportToMessage(exported telegramExportMessage, chatID int64) (db.Message, bool) {
+	userID, ok := parseTelegramPeerID(exported.FromID)
+	if !ok {
+		return db.Message{}, false
+	}
+
+	date, err := parseExportTime(exported.DateUnixtime, exported.Date)
+	if err != nil {
+		return db.Message{}, false
+	}
+
+	msg := db.Message{
+		MessageID:        exported.ID,
+		ChatID:           chatID,
+		UserID:           userID,
+		Text:             flattenExportText(exported.Text),
+		Date:             date,
+		ReplyToMessageID: exported.ReplyToMessageID,
+	}
+	if exported.Edited != "" || exported.EditedUnixtime != "" {
+		if editedAt, err := parseExportTime(exported.EditedUnixtime, exported.Edited); err == nil {
+			msg.EditedAt = editedAt
+		}
+	}
+
+	switch {
+	case exported.Photo != "":
+		msg.MediaType = "photo"
+	case exported.MediaType != "":
+		msg.MediaType = telegramMediaTypes[exported.MediaType]
+	case exported.File != "":
+		msg.MediaType = "document"
+	}
+	if msg.Text == "" {
+		if msg.MediaType == "" {
+			return db.Message{}, false
+		}
+		msg.Text = "[" + msg.MediaType + "]"
+	}
+
+	return msg, true
+}
+
+// flattenExportText turns the text field, which is either a string or a list
+// of strings and entity objects, into plain text.
+func flattenExportText(raw json.RawMessage) string {
+	var plain string
+	if err := json.Unmarshal(raw, &plain); err == nil {
+		return plain
+	}
+
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+
+	var sb strings.Builder
+	for _, part := range parts {
+		var text string
+		if err := json.Unmarshal(part, &text); err == nil {
+			sb.WriteString(text)
+			continue
+		}
+		var entity telegramExportEntity
+		if err := json.Unmarshal(part, &entity); err == nil {
+			sb.WriteString(entity.Text)
+			if entity.Href != "" && entity.Href != entity.Text {
+				sb.WriteString(" (" + entity.Href + ")")
+			}
+		}
+	}
+	return sb.String()
+}
+
+func parseExportTime(unix string, local string) (time.Time, error) {
+	if unix != "" {
+		seconds, err := strconv.ParseInt(unix, 10, 64)
+		if err == nil {
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05", local, time.Local)
+}
+
+// parseTelegramPeerID converts "user123" / "channel123" from_id values.
+func parseTelegramPeerID(fromID string) (int64, bool) {
+	if rest, ok := strings.CutPrefix(fromID, "user"); ok {
+		id, err := strconv.ParseInt(rest, 10, 64)
+		return id, err == nil
+	}
+	if rest, ok := strings.CutPrefix(fromID, "channel"); ok {
+		id, err := strconv.ParseInt("-100"+rest, 10, 64)
+		return id, err == nil
+	}
+	return 0, false
+}
+
+// telegramExportChatID converts the export's bare chat ID to a Bot API chat ID.
+func telegramExportChatID(chat telegramExportChat) int64 {
+	switch chat.Type {
+	case "private_supergroup", "public_supergroup", "private_channel", "public_channel":
+		id, _ := strconv.ParseInt("-100"+strconv.FormatInt(chat.ID, 10), 10, 64)
+		return id
+	case "private_group":
+		return -chat.ID
+	default:
+		return chat.ID
+	}
+}
+
+// exportHistory writes the whole stored history of a chat in the given format.
+func exportHistory(w io.Writer, chatID int64, format string, displayName func(int64) string) error {
+	switch format {
+	case exportFormatJSONL:
+		return exportHistoryJSONL(w, chatID)
+	case exportFormatTelegram:
+		return exportHistoryTelegram(w, chatID, displayName)
+	default:
+		return fmt.Errorf("unknown export format %q, use %s or %s", format, exportFormatJSONL, exportFormatTelegram)
+	}
+}
+
+func exportHistoryJSONL(w io.Writer, chatID int64) error {
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	err := forEachStoredMessage(chatID, func(msg db.Message) error {
+		line := exportedMessage{
+			MessageID:        msg.MessageID,
+			ChatID:           msg.ChatID,
+			UserID:           msg.UserID,
+			Text:             msg.Text,
+			AggregatedText:   msg.AggregatedText,
+			Date:             msg.Date,
+			ReplyToMessageID: msg.ReplyToMessageID,
+			TriggerMessageID: msg.TriggerMessageID,
+			Model:            msg.Model,
+			MediaType:        msg.MediaType,
+		}
+		if !msg.EditedAt.IsZero() {
+			line.EditedAt = &msg.EditedAt
+		}
+		return encoder.Encode(line)
+	})
+	if err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// exportHistoryTelegram writes a single-chat result.json that Telegram
+// export tooling (and importTelegramExport) understands.
+func exportHistoryTelegram(w io.Writer, chatID int64, displayName func(int64) string) error {
+	chat := telegramExportChat{Type: "private_supergroup", Messages: []telegramExportMessage{}}
+	id := strconv.FormatInt(chatID, 10)
+	switch {
+	case strings.HasPrefix(id, "-100"):
+		chat.ID, _ = strconv.ParseInt(strings.TrimPrefix(id, "-100"), 10, 64)
+	case chatID < 0:
+		chat.Type = "private_group"
+		chat.ID = -chatID
+	default:
+		chat.Type = "personal_chat"
+		chat.ID = chatID
+	}
+	chat.Name = fmt.Sprintf("Chat %d", chatID)
+
+	err := forEachStoredMessage(chatID, func(msg db.Message) error {
+		text, _ := json.Marshal(msg.Text)
+		exported := telegramExportMessage{
+			ID:               msg.MessageID,
+			Type:             "message",
+			Date:             msg.Date.Local().Format("2006-01-02T15:04:05"),
+			DateUnixtime:     strconv.FormatInt(msg.Date.Unix(), 10),
+			From:             displayName(msg.UserID),
+			FromID:           "user" + strconv.FormatInt(msg.UserID, 10),
+			ReplyToMessageID: msg.ReplyToMessageID,
+			Text:             text,
+			TextEntities:     []telegramExportEntity{{Type: "plain", Text: msg.Text}},
+		}
+		if !msg.EditedAt.IsZero() {
+			exported.Edited = msg.EditedAt.Local().Format("2006-01-02T15:04:05")
+			exported.EditedUnixtime = strconv.FormatInt(msg.EditedAt.Unix(), 10)
+		}
+		for exportType, mediaType := range telegramMediaTypes {
+			if mediaType == msg.MediaType {
+				exported.MediaType = exportType
+			}
+		}
+		if msg.MediaType == "photo" {
+			exported.Photo = "(File not included)"
+		} else if msg.MediaType != "" {
+			exported.File = "(File not included)"
+		}
+		chat.Messages = append(chat.Messages, exported)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", " ")
+	return encoder.Encode(chat)
+}
+
+func forEachStoredMessage(chatID int64, fn func(db.Message) error) error {
+	var afterID int64
+	for {
+		page, err := db.ListMessages(chatID, afterID, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, msg := range page {
+			if err := fn(msg); err != nil {
+				return err
+			}
+			afterID = msg.ID
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/api"
+)
+
+const (
+	// inlineDebounce is how long a query has to stay unchanged before it is
+	// answered; Telegram sends a new query on every keystroke.
+	inlineDebounce = 1 * time.Second
+	// inlineCacheTTL is how long answers are reused for the same query.
+	inlineCacheTTL = 10 * time.Minute
+	// inlineNoticeCacheTime is how long Telegram may show a notice such as
+	// "rate limited" again for the same query. It has to be positive: a zero
+	// cache time is not sent and Telegram then caches for five minutes.
+	inlineNoticeCacheTime = 1 * time.Second
+	// inlineRateLimitChatID is the chat bucket all inline queries share.
+	inlineRateLimitChatID = 0
+)
+
+// inlineTimeout bounds the completion behind an inline answer. Read from
+// INLINE_TIMEOUT_SECONDS.
+var inlineTimeout = 8 * time.Second
+
+// inlineAnswer is the model output for one inline query.
+type inlineAnswer struct {
+	Answer      string `json:"answer"`
+	Short       string `json:"short"`
+	Translation string `json:"translation"`
+}
+
+type inlineCacheEntry struct {
+	answer  inlineAnswer
+	created time.Time
+}
+
+var (
+	inlineCache     = make(map[string]inlineCacheEntry)
+	inlineLatest    = make(map[int64]string)
+	inlineCacheLock sync.Mutex
+)
+
+// handleInlineQuery answers "@bot question" from any chat. It runs outside
+// the per-chat dispatcher because only the newest query of a user matters.
+func handleInlineQuery(query *tgbotapi.InlineQuery) {
+	text := strings.TrimSpace(query.Query)
+	if text == "" {
+		return
+	}
+
+	inlineCacheLock.Lock()
+	inlineLatest[query.From.ID] = query.ID
+	inlineCacheLock.Unlock()
+
+	time.Sleep(inlineDebounce)
+
+	inlineCacheLock.Lock()
+	latest := inlineLatest[query.From.ID] == query.ID
+	inlineCacheLock.Unlock()
+	if !latest {
+		return
+	}
+
+	observeUser(query.From)
+	if !isAllowedChatMember(query.From.ID) {
+		answerInlineQuery(query, nil, "Only members of my chats can ask me here.")
+		return
+	}
+
+	lang := query.From.LanguageCode
+	if lang == "" {
+		lang = "en"
+	}
+	cacheKey := lang + ":" + strings.ToLower(text)
+
+	inlineCacheLock.Lock()
+	entry, cached := inlineCache[cacheKey]
+	inlineCacheLock.Unlock()
+	if cached && time.Since(entry.created) < inlineCacheTTL {
+		answerInlineQuery(query, &entry.answer, "")
+		return
+	}
+
+	if err := checkRateLimit(inlineRateLimitChatID, query.From.ID, limitKindRequest); err != nil {
+		answerInlineQuery(query, nil, err.Error())
+		return
+	}
+
+	answer, err := generateInlineAnswer(text, lang)
+	if err != nil {
+		log.Printf("Error answering inline query %q: %v", text, err)
+		answerInlineQuery(query, nil, "I couldn't answer in time, try again or mention me in the chat.")
+		return
+	}
+
+	inlineCacheLock.Lock()
+	for key, e := range inlineCache {
+		if time.Since(e.created) >= inlineCacheTTL {
+			delete(inlineCache, key)
+		}
+	}
+	inlineCache[cacheKey] = inlineCacheEntry{answer: *answer, created: time.Now()}
+	inlineCacheLock.Unlock()
+
+	answerInlineQuery(query, answer, "")
+}
+
+// generateInlineAnswer asks for the answer, a shorter variant and a
+// translation in one quick completion.
+func generateInlineAnswer(question string, lang string) (*inlineAnswer, error) {
+	reasoning, verbosity := "minimal", "low"
+	messages := []api.Message{
+		{
+			Role: "system",
+			Content: fmt.Sprintf("You answer questions typed into Telegram inline mode. Answer in the language of the question. "+
+				"Reply with a JSON object only, with the keys \"answer\" (a complete but concise answer), "+
+				"\"short\" (one or two sentences) and \"translation\" (the answer translated into the language with code %q, "+
+				"or into English if the answer already is in that language).", lang),
+		},
+		{Role: "user", Content: question},
+	}
+
+	completionResponse, err := api.CallChatCompletion(openAIToken, gptModelForChatting, messages,
+		api.ChatOptions{Reasoning: &reasoning, Verbosity: &verbosity, Timeout: inlineTimeout})
+	if err != nil {
+		return nil, err
+	}
+	if len(completionResponse.Choices) == 0 {
+		return nil, fmt.Errorf("no choices in response")
+	}
+
+	content := strings.TrimSpace(messageContentToString(completionResponse.Choices[0].Message.Content))
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.Trim(content, "`\n ")
+
+	var answer inlineAnswer
+	if err := json.Unmarshal([]byte(content), &answer); err != nil || answer.Answer == "" {
+		// Not JSON after all: offer the raw text as the only answer.
+		return &inlineAnswer{Answer: content}, nil
+	}
+	return &answer, nil
+}
+
+// answerInlineQuery sends the articles for answer, or a single article with
+// notice when there is no answer.
+func answerInlineQuery(query *tgbotapi.InlineQuery, answer *inlineAnswer, notice string) {
+	var results []interface{}
+	article := func(id, title, text string) {
+		if text == "" {
+			return
+		}
+		message := "❓ " + query.Query + "\n\n" + text
+		if utf8.RuneCountInString(message) > 4096 {
+			message = string([]rune(message)[:4095]) + "…"
+		}
+		result := tgbotapi.NewInlineQueryResultArticle(id, title, message)
+		result.Description = previewText(text, 100)
+		results = append(results, result)
+	}
+
+	cacheTime := inlineCacheTTL
+	if answer != nil {
+		article(query.ID+"-answer", "Answer", answer.Answer)
+		article(query.ID+"-short", "Shorter", answer.Short)
+		article(query.ID+"-translation", "Translation", answer.Translation)
+	} else {
+		article(query.ID+"-notice", notice, notice)
+		cacheTime = inlineNoticeCacheTime
+	}
+
+	config := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     int(cacheTime / time.Second),
+		IsPersonal:    true,
+	}
+	if _, err := bot.Request(config); err != nil {
+		log.Printf("Error answering inline query: %v", err)
+	}
+}
+
+// previewText shortens text to at most n runes on one line.
+func previewText(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n-1]) + "…"
+}
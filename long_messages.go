@@ -0,0 +1,275 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Telegram limits, counted in UTF-16 code units of the text without markup.
+const (
+	telegramTextLimit    = 4096
+	telegramCaptionLimit = 1024
+)
+
+const (
+	// maxMessageParts is the most messages one answer is split into; longer
+	// answers are sent as a file.
+	maxMessageParts = 3
+	// codeHeavyShare is the share of code above which an answer that does not
+	// fit into one message is sent as a file.
+	codeHeavyShare = 0.6
+)
+
+// codeFileExtensions maps code block languages to file extensions.
+var codeFileExtensions = map[string]string{
+	"go":         ".go",
+	"python":     ".py",
+	"py":         ".py",
+	"javascript": ".js",
+	"js":         ".js",
+	"typescript": ".ts",
+	"ts":         ".ts",
+	"java":       ".java",
+	"kotlin":     ".kt",
+	"c":          ".c",
+	"cpp":        ".cpp",
+	"c++":        ".cpp",
+	"csharp":     ".cs",
+	"cs":         ".cs",
+	"rust":       ".rs",
+	"php":        ".php",
+	"ruby":       ".rb",
+	"bash":       ".sh",
+	"sh":         ".sh",
+	"shell":      ".sh",
+	"sql":        ".sql",
+	"json":       ".json",
+	"yaml":       ".yaml",
+	"yml":        ".yaml",
+	"html":       ".html",
+	"css":        ".css",
+}
+
+// textLength counts s the way Telegram does.
+func textLength(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
+
+func isCodeFence(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "```")
+}
+
+// messageBlocks cuts text into the units a split may not go through: single
+// lines outside code blocks and whole fenced code blocks.
+func messageBlocks(text string) []string {
+	var blocks []string
+	var code []string
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case code != nil:
+			code = append(code, line)
+			if isCodeFence(line) {
+				blocks = append(blocks, strings.Join(code, "\n"))
+				code = nil
+			}
+		case isCodeFence(line):
+			code = []string{line}
+		default:
+			blocks = append(blocks, line)
+		}
+	}
+	if code != nil {
+		blocks = append(blocks, strings.Join(code, "\n"))
+	}
+	return blocks
+}
+
+// splitMessageText splits a markdown answer into parts of at most limit
+// characters. Parts end between lines and outside code blocks; only a code
+// block or line that is longer than limit by itself is cut, and a cut code
+// block is closed and reopened so every part renders on its own.
+func splitMessageText(text string, limit int) []string {
+	if textLength(text) <= limit {
+		return []string{text}
+	}
+
+	var parts []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if part := strings.Trim(current.String(), "\n"); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+		current.Reset()
+		currentLen = 0
+	}
+
+	for _, block := range messageBlocks(text) {
+		for _, piece := range splitBlock(block, limit) {
+			pieceLen := textLength(piece)
+			if currentLen > 0 && currentLen+1+pieceLen > limit {
+				flush()
+			}
+			if currentLen > 0 {
+				current.WriteString("\n")
+				currentLen++
+			}
+			current.WriteString(piece)
+			currentLen += pieceLen
+		}
+	}
+	flush()
+	return parts
+}
+
+// splitBlock cuts a single block that does not fit into one part.
+func splitBlock(block string, limit int) []string {
+	if textLength(block) <= limit {
+		return []string{block}
+	}
+	if !isCodeFence(block) {
+		return splitLine(block, limit)
+	}
+
+	lines := strings.Split(block, "\n")
+	opening, body := lines[0], lines[1:]
+	if len(body) > 0 && isCodeFence(body[len(body)-1]) {
+		body = body[:len(body)-1]
+	}
+	const closing = "```"
+	// Room left for code once the fences and their newlines are added.
+	room := limit - textLength(opening) - textLength(closing) - 2
+
+	var pieces []string
+	var chunk []string
+	chunkLen := 0
+	emit := func() {
+		if len(chunk) > 0 {
+			pieces = append(pieces, opening+"\n"+strings.Join(chunk, "\n")+"\n"+closing)
+		}
+		chunk, chunkLen = nil, 0
+	}
+	for _, line := range body {
+		for _, piece := range splitLine(line, room) {
+			pieceLen := textLength(piece)
+			if len(chunk) > 0 && chunkLen+1+pieceLen > room {
+				emit()
+			}
+			if len(chunk) > 0 {
+				chunkLen++
+			}
+			chunk = append(chunk, piece)
+			chunkLen += pieceLen
+		}
+	}
+	emit()
+	return pieces
+}
+
+// splitLine cuts a line into pieces of at most limit characters, at the last
+// space where possible.
+func splitLine(line string, limit int) []string {
+	var pieces []string
+	for textLength(line) > limit {
+		cut, n, lastSpace := 0, 0, -1
+		for i, r := range line {
+			if n+utf16.RuneLen(r) > limit {
+				break
+			}
+			n += utf16.RuneLen(r)
+			cut = i + utf8.RuneLen(r)
+			if r == ' ' {
+				lastSpace = i
+			}
+		}
+		if lastSpace > 0 {
+			cut = lastSpace
+		} else if cut == 0 {
+			// Always make progress, even if one character is over the limit.
+			_, cut = utf8.DecodeRuneInString(line)
+		}
+		pieces = append(pieces, line[:cut])
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	return append(pieces, line)
+}
+
+// codeShare returns the share of text inside fenced code blocks.
+func codeShare(text string) float64 {
+	total, code := 0, 0
+	for _, block := range messageBlocks(text) {
+		n := textLength(block)
+		total += n
+		if isCodeFence(block) {
+			code += n
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(code) / float64(total)
+}
+
+// sendAsFile reports whether an answer should be sent as a document instead
+// of parts.
+func sendAsFile(text string, parts []string) bool {
+	if len(parts) > maxMessageParts {
+		return true
+	}
+	return len(parts) > 1 && codeShare(text) >= codeHeavyShare
+}
+
+// answerFile returns the file name and content for an answer sent as a
+// document: answers that are one code block become a source file of that
+// language, everything else a markdown file.
+func answerFile(text string) (string, []byte) {
+	blocks := messageBlocks(strings.TrimSpace(text))
+	var code []string
+	for _, block := range blocks {
+		if isCodeFence(block) {
+			code = append(code, block)
+		}
+	}
+	if len(code) == 1 && codeShare(text) >= 0.9 {
+		lines := strings.Split(code[0], "\n")
+		lang := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[0]), "```")))
+		if ext, ok := codeFileExtensions[lang]; ok {
+			body := lines[1:]
+			if len(body) > 0 && isCodeFence(body[len(body)-1]) {
+				body = body[:len(body)-1]
+			}
+			return "answer" + ext, []byte(strings.Join(body, "\n") + "\n")
+		}
+	}
+	return "answer.md", []byte(text)
+}
+
+// answerFileCaption is the caption of an answer sent as a document: its
+// first paragraph outside code, cut to the caption limit.
+func answerFileCaption(text string) string {
+	caption := ""
+	for _, block := range messageBlocks(text) {
+		if isCodeFence(block) {
+			break
+		}
+		if strings.TrimSpace(block) == "" {
+			if caption != "" {
+				break
+			}
+			continue
+		}
+		caption = strings.TrimSpace(caption + "\n" + block)
+	}
+	if caption == "" {
+		return "The answer is long, so here it is as a file."
+	}
+	if textLength(caption) > telegramCaptionLimit {
+		caption = splitLine(caption, telegramCaptionLimit-1)[0] + "…"
+	}
+	return caption
+}
@@ -24,11 +24,11 @@ const (
 )
 
 type mediaItem struct {
-	FileID         string
-	MimeType       string
-	FileName       string
-	Kind           string
-	FallbackFileID string
+	FileID         string `json:"file_id"`
+	MimeType       string `json:"mime_type,omitempty"`
+	FileName       string `json:"file_name,omitempty"`
+	Kind           string `json:"kind"`
+	FallbackFileID string `json:"fallback_file_id,omitempty"`
 }
 
 func hasSupportedMedia(message *tgbotapi.Message) bool {
@@ -60,6 +60,30 @@ func hasSupportedMedia(message *tgbotapi.Message) bool {
 	return false
 }
 
+// messageMediaType names the attachment kind of a message for storage, or
+// returns "" for plain text messages.
+func messageMediaType(message *tgbotapi.Message) string {
+	switch {
+	case len(message.Photo) > 0:
+		return "photo"
+	case message.Video != nil:
+		return "video"
+	case message.Animation != nil:
+		return "animation"
+	case message.VideoNote != nil:
+		return "video_note"
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Voice != nil:
+		return "voice"
+	case message.Audio != nil:
+		return "audio"
+	case message.Document != nil:
+		return "document"
+	}
+	return ""
+}
+
 func mediaGroupKey(chatID int64, groupID string) string {
 	return fmt.Sprintf("%d:%s", chatID, groupID)
 }
@@ -97,6 +121,23 @@ func recordMediaGroup(message *tgbotapi.Message) {
 	cleanupMediaGroupCacheLocked(entry.updated)
 }
 
+// forgetMediaGroupsOfUser drops cached media groups sent by userID.
+func forgetMediaGroupsOfUser(chatID int64, userID int64) {
+	mediaGroupCacheLock.Lock()
+	defer mediaGroupCacheLock.Unlock()
+
+	for key, entry := range mediaGroupCache {
+		for id, msg := range entry.messages {
+			if msg.Chat.ID == chatID && msg.From != nil && msg.From.ID == userID {
+				delete(entry.messages, id)
+			}
+		}
+		if len(entry.messages) == 0 {
+			delete(mediaGroupCache, key)
+		}
+	}
+}
+
 func getMediaGroupMessages(chatID int64, groupID string) []*tgbotapi.Message {
 	key := mediaGroupKey(chatID, groupID)
 
@@ -193,17 +234,23 @@ func extractMediaItems(message *tgbotapi.Message) []mediaItem {
 	return nil
 }
 
-func downloadMediaMessagesAsDataURLs(messages []*tgbotapi.Message) ([]string, error) {
+// collectMediaItems returns the media the model should see for a message.
+func collectMediaItems(message *tgbotapi.Message) []mediaItem {
+	var items []mediaItem
+	for _, msg := range collectMediaMessages(message) {
+		items = append(items, extractMediaItems(msg)...)
+	}
+	return items
+}
+
+func downloadMediaItemsAsDataURLs(items []mediaItem) ([]string, error) {
 	var urls []string
-	for _, msg := range messages {
-		items := extractMediaItems(msg)
-		for _, item := range items {
-			itemURLs, err := downloadMediaItemAsDataURLs(item)
-			if err != nil {
-				return nil, err
-			}
-			urls = append(urls, itemURLs...)
+	for _, item := range items {
+		itemURLs, err := downloadMediaItemAsDataURLs(item)
+		if err != nil {
+			return nil, err
 		}
+		urls = append(urls, itemURLs...)
 	}
 
 	if len(urls) == 0 {
@@ -84,7 +84,7 @@ func shouldUseWebSearch(userText, replyText string) bool {
 	if containsTrigger(combined) {
 		return true
 	}
-	fmt.Println("gptModelForRouting: %s\n", gptModelForRouting)
+	fmt.Printf("gptModelForRouting: %s\n", gptModelForRouting)
 
 	if combined == "" || gptModelForRouting == "" {
 		return false
@@ -107,9 +107,9 @@ func shouldUseWebSearch(userText, replyText string) bool {
 	}
 
 	resp, err := api.CallChatCompletion(openAIToken, requestBody.Model, requestBody.Messages, api.ChatOptions{})
-	fmt.Println("resp: %v\n", resp)
+	fmt.Printf("resp: %v\n", resp)
 	if err != nil {
-		log.Println("Routing model error: %v", err)
+		log.Printf("Routing model error: %v", err)
 		return false
 	}
 
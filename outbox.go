@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// sendPriority orders the outgoing queue: replies to users go before
+// background posts such as alerts and digests.
+type sendPriority int
+
+const (
+	priorityReply sendPriority = iota
+	priorityBackground
+	priorityCount
+)
+
+// Telegram flood limits: about 30 messages per second overall, one per
+// second in a private chat and 20 per minute in a group. Short bursts are
+// tolerated, so every limit is a token bucket.
+const (
+	globalSendRate       = 30.0
+	globalSendBurst      = 30.0
+	privateChatSendRate  = 1.0
+	privateChatSendBurst = 3.0
+	groupChatSendRate    = 20.0 / 60
+	groupChatSendBurst   = 5.0
+
+	// maxSendAttempts bounds retries of network and server errors; flood
+	// control waits do not count.
+	maxSendAttempts = 4
+	// maxFloodWaits bounds how often one message waits for retry_after.
+	maxFloodWaits = 5
+	// outgoingWorkers is how many requests may be in flight at once.
+	outgoingWorkers = 8
+	// maxTrackedChats is how many chat states are kept before idle ones are
+	// dropped.
+	maxTrackedChats = 1000
+)
+
+// outbox is the queue all outgoing messages and edits go through. It is
+// created in initApp.
+var outbox *outgoingQueue
+
+type outgoing struct {
+	chatID    int64
+	priority  sendPriority
+	do        func() error
+	notBefore time.Time
+	attempts  int
+	floodWait int
+	done      chan error
+}
+
+type sendBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// take refills the bucket and consumes a token if one is available. It
+// returns how long to wait otherwise.
+func (b *sendBucket) take(rate float64, burst float64, now time.Time) (bool, time.Duration) {
+	if b.updated.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	}
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+type chatSendState struct {
+	bucket sendBucket
+	// busy is set while a request of the chat is in flight, so messages of
+	// one chat are sent in order.
+	busy bool
+	// blockedUntil is set from retry_after.
+	blockedUntil time.Time
+}
+
+type outgoingQueue struct {
+	mu      sync.Mutex
+	pending [priorityCount][]*outgoing
+	chats   map[int64]*chatSendState
+	global  sendBucket
+	wake    chan struct{}
+	workers chan struct{}
+}
+
+func newOutgoingQueue() *outgoingQueue {
+	q := &outgoingQueue{
+		chats:   make(map[int64]*chatSendState),
+		wake:    make(chan struct{}, 1),
+		workers: make(chan struct{}, outgoingWorkers),
+	}
+	go q.run()
+	return q
+}
+
+// sendQueued sends c like bot.Send, through the outgoing queue.
+func sendQueued(chatID int64, c tgbotapi.Chattable, priority sendPriority) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	err := outbox.enqueue(chatID, priority, func() error {
+		var err error
+		sent, err = bot.Send(c)
+		return err
+	})
+	return sent, err
+}
+
+// requestQueued makes the request c like bot.Request, through the outgoing
+// queue. Use it for requests that do not return a message, e.g. deletes.
+func requestQueued(chatID int64, c tgbotapi.Chattable, priority sendPriority) error {
+	return outbox.enqueue(chatID, priority, func() error {
+		_, err := bot.Request(c)
+		return err
+	})
+}
+
+// enqueue adds a request and waits until it was sent or failed for good.
+func (q *outgoingQueue) enqueue(chatID int64, priority sendPriority, do func() error) error {
+	item := &outgoing{chatID: chatID, priority: priority, do: do, done: make(chan error, 1)}
+	q.mu.Lock()
+	q.pending[priority] = append(q.pending[priority], item)
+	q.mu.Unlock()
+	q.signal()
+	return <-item.done
+}
+
+func (q *outgoingQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *outgoingQueue) run() {
+	for {
+		item, wait := q.next(time.Now())
+		if item == nil {
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-q.wake:
+				case <-timer.C:
+				}
+				timer.Stop()
+			} else {
+				<-q.wake
+			}
+			continue
+		}
+
+		q.workers <- struct{}{}
+		go func() {
+			q.attempt(item)
+			<-q.workers
+		}()
+	}
+}
+
+// next removes and returns the first request that may be sent now, replies
+// first. Otherwise it returns how long until one may be ready, or zero if
+// only an in-flight request or a new one can change that.
+func (q *outgoingQueue) next(now time.Time) (*outgoing, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var wait time.Duration
+	later := func(d time.Duration) {
+		if d > 0 && (wait == 0 || d < wait) {
+			wait = d
+		}
+	}
+
+	for priority := range q.pending {
+		// A chat whose first request has to wait holds back its later ones.
+		held := make(map[int64]bool)
+		for i, item := range q.pending[priority] {
+			if held[item.chatID] {
+				continue
+			}
+			state := q.chatState(item.chatID)
+			if state.busy {
+				held[item.chatID] = true
+				continue
+			}
+			if until := maxTime(state.blockedUntil, item.notBefore); until.After(now) {
+				held[item.chatID] = true
+				later(until.Sub(now))
+				continue
+			}
+			rate, burst := chatSendLimits(item.chatID)
+			if ok, d := state.bucket.take(rate, burst, now); !ok {
+				held[item.chatID] = true
+				later(d)
+				continue
+			}
+			if ok, d := q.global.take(globalSendRate, globalSendBurst, now); !ok {
+				// Give the chat its token back; nothing else can go out now.
+				state.bucket.tokens++
+				later(d)
+				return nil, wait
+			}
+
+			q.pending[priority] = append(q.pending[priority][:i:i], q.pending[priority][i+1:]...)
+			state.busy = true
+			return item, 0
+		}
+	}
+	return nil, wait
+}
+
+func (q *outgoingQueue) chatState(chatID int64) *chatSendState {
+	state, ok := q.chats[chatID]
+	if !ok {
+		state = &chatSendState{}
+		q.chats[chatID] = state
+	}
+	return state
+}
+
+// attempt makes the request once. Flood control and transient failures put
+// it back at the front of its queue, anything else is returned to the sender.
+func (q *outgoingQueue) attempt(item *outgoing) {
+	err := item.do()
+	item.attempts++
+	now := time.Now()
+
+	q.mu.Lock()
+	state := q.chatState(item.chatID)
+	state.busy = false
+	retry := false
+	if retryAfter := floodRetryAfter(err); retryAfter > 0 && item.floodWait < maxFloodWaits {
+		log.Printf("Flood control in chat %d, retrying in %s", item.chatID, retryAfter)
+		item.floodWait++
+		item.attempts--
+		state.blockedUntil = now.Add(retryAfter)
+		retry = true
+	} else if isTransientSendError(err) && item.attempts < maxSendAttempts {
+		backoff := time.Duration(1<<(item.attempts-1)) * time.Second
+		log.Printf("Error sending to chat %d: %v, retrying in %s", item.chatID, err, backoff)
+		item.notBefore = now.Add(backoff)
+		retry = true
+	}
+	if retry {
+		q.pending[item.priority] = append([]*outgoing{item}, q.pending[item.priority]...)
+	} else if len(q.chats) > maxTrackedChats {
+		// Forget idle chats now and then so the map does not grow forever.
+		for chatID, s := range q.chats {
+			if !s.busy && !s.blockedUntil.After(now) {
+				delete(q.chats, chatID)
+			}
+		}
+	}
+	q.mu.Unlock()
+
+	if !retry {
+		item.done <- err
+	}
+	q.signal()
+}
+
+// queueDepths returns the number of waiting requests per priority.
+func (q *outgoingQueue) queueDepths() [priorityCount]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var depths [priorityCount]int
+	for priority, items := range q.pending {
+		depths[priority] = len(items)
+	}
+	return depths
+}
+
+func chatSendLimits(chatID int64) (float64, float64) {
+	// Group and channel IDs are negative, private chats use the user ID.
+	if chatID < 0 {
+		return groupChatSendRate, groupChatSendBurst
+	}
+	return privateChatSendRate, privateChatSendBurst
+}
+
+// floodRetryAfter returns the wait Telegram asked for in a 429 response.
+func floodRetryAfter(err error) time.Duration {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// isTransientSendError reports whether err may go away by itself: network
+// errors and server errors. Other errors, such as a bad request, are final.
+func isTransientSendError(err error) bool {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
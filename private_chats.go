@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+// handleResetCommand starts a fresh conversation in a private chat: earlier
+// messages stay stored and searchable but are no longer sent to the model.
+func handleResetCommand(message *tgbotapi.Message) {
+	err := db.SetConversationReset(db.ConversationReset{
+		ChatID:         message.Chat.ID,
+		AfterMessageID: message.MessageID,
+		ResetBy:        message.From.ID,
+		ResetAt:        time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error resetting conversation in chat %d: %v", message.Chat.ID, err)
+		replyToCommand(message, "Failed to reset the conversation.")
+		return
+	}
+	replyToCommand(message, "Done, let's start over. I won't look at our earlier messages.")
+}
+
+// afterConversationReset drops messages sent before the last /reset of the
+// chat.
+func afterConversationReset(chatID int64, messages []db.Message) []db.Message {
+	reset, err := db.GetConversationReset(chatID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error loading conversation reset of chat %d: %v", chatID, err)
+		}
+		return messages
+	}
+
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.MessageID > reset.AfterMessageID {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
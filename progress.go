@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// chatActionInterval is how often the chat action is repeated; Telegram shows
+// one for about five seconds.
+const chatActionInterval = 4 * time.Second
+
+// progressStatusDelay is how long a request may run before a "still
+// thinking" message is posted. Read from PROGRESS_STATUS_DELAY_SECONDS; zero
+// disables the status message.
+var progressStatusDelay = 15 * time.Second
+
+// progressIndicator shows that the bot is working on a message: a chat
+// action such as "typing…" while the request runs and, for slow requests, a
+// status message that the answer later replaces.
+type progressIndicator struct {
+	trigger    *tgbotapi.Message
+	action     string
+	withStatus bool
+
+	stopped chan struct{}
+	done    chan struct{}
+	// status is only written by run and read after done is closed.
+	status *tgbotapi.Message
+}
+
+// startProgress starts the chat action and the delayed status message for
+// an answer to trigger.
+func startProgress(trigger *tgbotapi.Message, action string) *progressIndicator {
+	return newProgress(trigger, action, progressStatusDelay > 0)
+}
+
+// keepChatAction shows action in the chat until the returned function is
+// called, without a status message.
+func keepChatAction(chat *tgbotapi.Chat, action string) (stop func()) {
+	p := newProgress(&tgbotapi.Message{Chat: chat}, action, false)
+	return func() { p.stop() }
+}
+
+func newProgress(trigger *tgbotapi.Message, action string, withStatus bool) *progressIndicator {
+	p := &progressIndicator{
+		trigger:    trigger,
+		action:     action,
+		withStatus: withStatus,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *progressIndicator) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(chatActionInterval)
+	defer ticker.Stop()
+	var statusDue <-chan time.Time
+	if p.withStatus {
+		timer := time.NewTimer(progressStatusDelay)
+		defer timer.Stop()
+		statusDue = timer.C
+	}
+
+	p.sendAction()
+	for {
+		select {
+		case <-p.stopped:
+			return
+		case <-ticker.C:
+			p.sendAction()
+		case <-statusDue:
+			statusDue = nil
+			msg := tgbotapi.NewMessage(p.trigger.Chat.ID, "Still thinking…")
+			msg.ReplyToMessageID = p.trigger.MessageID
+			msg.DisableNotification = true
+			sent, err := sendQueued(msg.ChatID, msg, priorityReply)
+			if err != nil {
+				log.Printf("Error sending status message in chat %d: %v", p.trigger.Chat.ID, err)
+				continue
+			}
+			p.status = &sent
+			// A sent message ends the chat action.
+			p.sendAction()
+		}
+	}
+}
+
+func (p *progressIndicator) sendAction() {
+	if _, err := bot.Request(tgbotapi.NewChatAction(p.trigger.Chat.ID, p.action)); err != nil {
+		log.Printf("Error sending chat action to chat %d: %v", p.trigger.Chat.ID, err)
+	}
+}
+
+// stop ends the chat action and returns the status message, if one was sent.
+func (p *progressIndicator) stop() *tgbotapi.Message {
+	select {
+	case <-p.stopped:
+	default:
+		close(p.stopped)
+	}
+	<-p.done
+	return p.status
+}
+
+// deliver sends the answer like sendReply, editing the status message into
+// the answer when one was posted and the answer fits into it.
+func (p *progressIndicator) deliver(msg tgbotapi.MessageConfig, model string) *tgbotapi.Message {
+	status := p.stop()
+	if status == nil {
+		return sendReply(msg, p.trigger, model)
+	}
+	if len(splitMessageText(msg.Text, telegramTextLimit)) > 1 {
+		// Answers that need several messages or a file are sent anew.
+		p.dismiss()
+		return sendReply(msg, p.trigger, model)
+	}
+
+	var markup *tgbotapi.InlineKeyboardMarkup
+	if keyboard, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); ok {
+		markup = &keyboard
+	}
+	if err := editBotMessage(status.Chat.ID, status.MessageID, msg.Text, markup); err != nil {
+		log.Printf("Error replacing status message %d: %v", status.MessageID, err)
+		p.dismiss()
+		return sendReply(msg, p.trigger, model)
+	}
+	saveBotReply(status, msg.Text, model, p.trigger.MessageID)
+	return status
+}
+
+// dismiss stops the indicator and deletes the status message, for requests
+// that end without an answer.
+func (p *progressIndicator) dismiss() {
+	status := p.stop()
+	if status == nil {
+		return
+	}
+	if err := requestQueued(status.Chat.ID, tgbotapi.NewDeleteMessage(status.Chat.ID, status.MessageID), priorityReply); err != nil {
+		log.Printf("Error deleting status message %d: %v", status.MessageID, err)
+	}
+}
+
+// mentionChatAction picks the chat action shown while answering message.
+func mentionChatAction(message *tgbotapi.Message) string {
+	if len(collectMediaMessages(message)) > 0 {
+		return tgbotapi.ChatUploadPhoto
+	}
+	return tgbotapi.ChatTyping
+}
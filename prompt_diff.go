@@ -0,0 +1,74 @@
+package main
+
+import "strings"
+
+// diffRow is one line of a side-by-side diff. Line numbers are 0 where the
+// side has no line.
+type diffRow struct {
+	Kind      string // "same", "added", "removed" or "changed"
+	Left      string
+	Right     string
+	LeftLine  int
+	RightLine int
+}
+
+// sideBySideDiff compares two texts line by line using the longest common
+// subsequence. Removed lines directly followed by added lines are paired up
+// as changed rows.
+func sideBySideDiff(oldText, newText string) []diffRow {
+	oldLines := strings.Split(oldText, "\n")
+	newLines := strings.Split(newText, "\n")
+
+	// lcs[i][j] is the LCS length of oldLines[i:] and newLines[j:].
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var rows []diffRow
+	var removed, added []diffRow
+	flush := func() {
+		for k := 0; k < max(len(removed), len(added)); k++ {
+			switch {
+			case k < len(removed) && k < len(added):
+				rows = append(rows, diffRow{Kind: "changed",
+					Left: removed[k].Left, LeftLine: removed[k].LeftLine,
+					Right: added[k].Right, RightLine: added[k].RightLine})
+			case k < len(removed):
+				rows = append(rows, removed[k])
+			default:
+				rows = append(rows, added[k])
+			}
+		}
+		removed, added = removed[:0], added[:0]
+	}
+
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			flush()
+			rows = append(rows, diffRow{Kind: "same", Left: oldLines[i], Right: newLines[j], LeftLine: i + 1, RightLine: j + 1})
+			i++
+			j++
+		case j < len(newLines) && (i == len(oldLines) || lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, diffRow{Kind: "added", Right: newLines[j], RightLine: j + 1})
+			j++
+		default:
+			removed = append(removed, diffRow{Kind: "removed", Left: oldLines[i], LeftLine: i + 1})
+			i++
+		}
+	}
+	flush()
+
+	return rows
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+// Kinds of model requests that are limited separately. Every request counts
+// as limitKindRequest; web search and media requests also count as their own
+// kind.
+const (
+	limitKindRequest = "request"
+	limitKindSearch  = "search"
+	limitKindMedia   = "media"
+)
+
+var limitKinds = []string{limitKindRequest, limitKindSearch, limitKindMedia}
+
+// limitConfig holds the limits of one kind. Hourly limits are token buckets
+// that refill continuously; daily limits are counted in the database and
+// reset at local midnight. Zero means unlimited.
+type limitConfig struct {
+	UserPerHour int
+	ChatPerHour int
+	UserPerDay  int
+	ChatPerDay  int
+}
+
+// rateLimits can be overridden per kind with RATE_LIMIT_REQUEST,
+// RATE_LIMIT_SEARCH and RATE_LIMIT_MEDIA, e.g. "user:30/h,chat:120/h,user:200/d".
+var rateLimits = map[string]limitConfig{
+	limitKindRequest: {UserPerHour: 30, ChatPerHour: 120},
+	limitKindSearch:  {UserPerHour: 6, ChatPerHour: 20, UserPerDay: 30},
+	limitKindMedia:   {UserPerHour: 10, ChatPerHour: 40},
+}
+
+// usageRetentionDays is how long daily counters are kept.
+const usageRetentionDays = 7
+
+// maxTrackedBuckets is how many token buckets are kept before idle ones are
+// dropped.
+const maxTrackedBuckets = 10000
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+var (
+	rateBuckets     = make(map[string]*tokenBucket)
+	rateBucketsLock sync.Mutex
+)
+
+// rateLimitError is returned when a request is over a limit. Its text is
+// shown to the user.
+type rateLimitError struct {
+	reason     string
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("Easy there, %s. Please try again in %s.", e.reason, formatRetryAfter(e.retryAfter))
+}
+
+func formatRetryAfter(d time.Duration) string {
+	minutes := int(math.Ceil(d.Minutes()))
+	switch {
+	case minutes <= 1:
+		return "a minute"
+	case minutes < 120:
+		return fmt.Sprintf("%d minutes", minutes)
+	default:
+		return fmt.Sprintf("%d hours", int(math.Ceil(d.Hours())))
+	}
+}
+
+func initRateLimits() {
+	for _, kind := range limitKinds {
+		name := "RATE_LIMIT_" + strings.ToUpper(kind)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		cfg, err := parseLimitConfig(rateLimits[kind], value)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", name, err)
+		}
+		rateLimits[kind] = cfg
+	}
+}
+
+// parseLimitConfig applies "scope:N/period" entries (scope user or chat,
+// period h or d) on top of cfg.
+func parseLimitConfig(cfg limitConfig, value string) (limitConfig, error) {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		scope, rest, ok1 := strings.Cut(entry, ":")
+		count, period, ok2 := strings.Cut(rest, "/")
+		n, err := strconv.Atoi(count)
+		if !ok1 || !ok2 || err != nil || n < 0 {
+			return cfg, fmt.Errorf("bad entry %q, expected e.g. user:30/h", entry)
+		}
+		switch scope + "/" + period {
+		case "user/h":
+			cfg.UserPerHour = n
+		case "chat/h":
+			cfg.ChatPerHour = n
+		case "user/d":
+			cfg.UserPerDay = n
+		case "chat/d":
+			cfg.ChatPerDay = n
+		default:
+			return cfg, fmt.Errorf("bad entry %q, scope must be user or chat and period h or d", entry)
+		}
+	}
+	return cfg, nil
+}
+
+// requestKinds returns the limit kinds a model request counts against.
+func requestKinds(search bool, media bool) []string {
+	kinds := []string{limitKindRequest}
+	if search {
+		kinds = append(kinds, limitKindSearch)
+	}
+	if media {
+		kinds = append(kinds, limitKindMedia)
+	}
+	return kinds
+}
+
+// isRateLimitExempt reports whether userID skips all limits: the bot admin
+// and users exempted with /ratelimit exempt.
+func isRateLimitExempt(userID int64) bool {
+	if userID == adminChatID {
+		return true
+	}
+	exempt, err := db.IsRateLimitExempt(userID)
+	if err != nil {
+		log.Printf("Error checking rate limit exemption of user %d: %v", userID, err)
+	}
+	return exempt
+}
+
+// checkRateLimit consumes one request of every kind for the user and chat,
+// or returns a *rateLimitError without consuming anything. Hourly tokens are
+// taken first and given back if a daily limit is reached, so the database is
+// not queried under rateBucketsLock.
+func checkRateLimit(chatID int64, userID int64, kinds ...string) error {
+	if isRateLimitExempt(userID) {
+		return nil
+	}
+
+	now := time.Now()
+	taken, err := takeTokens(chatID, userID, kinds, now)
+	if err != nil {
+		return err
+	}
+
+	day := now.Format("2006-01-02")
+	var counters []db.UsageKey
+	for _, kind := range kinds {
+		cfg := rateLimits[kind]
+		for _, limit := range []struct {
+			scope  string
+			id     int64
+			perDay int
+		}{
+			{db.UsageScopeUser, userID, cfg.UserPerDay},
+			{db.UsageScopeChat, chatID, cfg.ChatPerDay},
+		} {
+			key := db.UsageKey{Day: day, Scope: limit.scope, SubjectID: limit.id, Kind: kind}
+			counters = append(counters, key)
+			if limit.perDay <= 0 {
+				continue
+			}
+			used, err := db.GetUsage(key)
+			if err != nil {
+				log.Printf("Error reading usage %+v: %v", key, err)
+				continue
+			}
+			if used >= limit.perDay {
+				returnTokens(taken)
+				return &rateLimitError{
+					reason:     limitReason(kind, limit.scope, "today"),
+					retryAfter: nextMidnight(now).Sub(now),
+				}
+			}
+		}
+	}
+
+	for _, key := range counters {
+		if _, err := db.IncrementUsage(key); err != nil {
+			log.Printf("Error counting usage %+v: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// takeTokens takes one token from every hourly bucket of the request and
+// returns their keys, or takes none and returns a *rateLimitError.
+func takeTokens(chatID int64, userID int64, kinds []string, now time.Time) ([]string, error) {
+	rateBucketsLock.Lock()
+	defer rateBucketsLock.Unlock()
+
+	var keys []string
+	var buckets []*tokenBucket
+	for _, kind := range kinds {
+		cfg := rateLimits[kind]
+		for _, limit := range []struct {
+			scope   string
+			id      int64
+			perHour int
+		}{
+			{db.UsageScopeUser, userID, cfg.UserPerHour},
+			{db.UsageScopeChat, chatID, cfg.ChatPerHour},
+		} {
+			if limit.perHour <= 0 {
+				continue
+			}
+			key := fmt.Sprintf("%s:%s:%d", kind, limit.scope, limit.id)
+			b := refillBucket(key, limit.perHour, now)
+			if b.tokens < 1 {
+				perSecond := float64(limit.perHour) / 3600
+				return nil, &rateLimitError{
+					reason:     limitReason(kind, limit.scope, "right now"),
+					retryAfter: time.Duration((1 - b.tokens) / perSecond * float64(time.Second)),
+				}
+			}
+			keys = append(keys, key)
+			buckets = append(buckets, b)
+		}
+	}
+
+	for _, b := range buckets {
+		b.tokens--
+	}
+	if len(rateBuckets) > maxTrackedBuckets {
+		// A bucket left alone for an hour is full again, so it can be dropped
+		// and recreated on the next request.
+		for key, b := range rateBuckets {
+			if now.Sub(b.updated) >= time.Hour {
+				delete(rateBuckets, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// returnTokens gives back the tokens taken by takeTokens.
+func returnTokens(keys []string) {
+	rateBucketsLock.Lock()
+	defer rateBucketsLock.Unlock()
+
+	for _, key := range keys {
+		if b, ok := rateBuckets[key]; ok {
+			b.tokens++
+		}
+	}
+}
+
+// refillBucket returns the bucket for key topped up to now. A bucket holds
+// at most a quarter of the hourly limit, so the limit cannot be spent in one
+// burst.
+func refillBucket(key string, perHour int, now time.Time) *tokenBucket {
+	capacity := math.Max(1, float64(perHour)/4)
+	b, ok := rateBuckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		rateBuckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Hours()*float64(perHour))
+	b.updated = now
+	return b
+}
+
+func limitReason(kind string, scope string, period string) string {
+	what := map[string]string{
+		limitKindRequest: "requests",
+		limitKindSearch:  "web searches",
+		limitKindMedia:   "image requests",
+	}[kind]
+	if scope == db.UsageScopeChat {
+		return fmt.Sprintf("this chat has used up its %s %s", what, period)
+	}
+	return fmt.Sprintf("you have used up your %s %s", what, period)
+}
+
+func nextMidnight(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+}
+
+// purgeOldUsage drops daily counters older than usageRetentionDays.
+func purgeOldUsage() {
+	day := time.Now().AddDate(0, 0, -usageRetentionDays).Format("2006-01-02")
+	if err := db.PurgeUsage(day); err != nil {
+		log.Printf("Error purging usage counters: %v", err)
+	}
+}
+
+// handleRateLimitCommand shows today's usage of the caller or, for the bot
+// admin, manages exemptions: /ratelimit exempt|unexempt @name (or as a
+// reply) and /ratelimit exemptions. Exemptions apply in every chat, so no
+// chat admin can grant them.
+func handleRateLimitCommand(message *tgbotapi.Message) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyToMessageID = message.MessageID
+		sendMessage(msg, false)
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		reply(describeUsage(message.From.ID))
+		return
+	}
+
+	if message.From.ID != adminChatID {
+		reply("Only the bot admin can manage rate limit exemptions.")
+		return
+	}
+
+	switch args[0] {
+	case "exemptions":
+		userIDs, err := db.ListRateLimitExemptions()
+		if err != nil {
+			log.Printf("Error listing rate limit exemptions: %v", err)
+			reply("Failed to load exemptions.")
+			return
+		}
+		if len(userIDs) == 0 {
+			reply("Nobody is exempt from rate limits.")
+			return
+		}
+		names := make([]string, len(userIDs))
+		for i, userID := range userIDs {
+			names[i] = resolveUsername(message.Chat.ID, userID)
+		}
+		reply("Exempt from rate limits: " + strings.Join(names, ", "))
+	case "exempt", "unexempt":
+		var targetID int64
+		if len(args) > 1 {
+			userID, found := findUserIDByUsername(args[1])
+			if !found {
+				reply(fmt.Sprintf("I don't know user %s.", args[1]))
+				return
+			}
+			targetID = userID
+		} else if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+			targetID = message.ReplyToMessage.From.ID
+		} else {
+			reply("Usage: /ratelimit " + args[0] + " @name, or reply to one of their messages.")
+			return
+		}
+
+		exempt := args[0] == "exempt"
+		if err := db.SetRateLimitExempt(targetID, exempt, message.From.ID); err != nil {
+			log.Printf("Error saving rate limit exemption: %v", err)
+			reply("Failed to save the exemption.")
+			return
+		}
+		name := resolveUsername(message.Chat.ID, targetID)
+		if exempt {
+			reply(name + " is now exempt from rate limits.")
+		} else {
+			reply(name + " is rate limited again.")
+		}
+	default:
+		reply("Usage: /ratelimit, /ratelimit exempt|unexempt @name, /ratelimit exemptions")
+	}
+}
+
+func describeUsage(userID int64) string {
+	if isRateLimitExempt(userID) {
+		return "You are exempt from rate limits."
+	}
+
+	day := time.Now().Format("2006-01-02")
+	lines := []string{"Your usage today:"}
+	for _, kind := range limitKinds {
+		used, err := db.GetUsage(db.UsageKey{Day: day, Scope: db.UsageScopeUser, SubjectID: userID, Kind: kind})
+		if err != nil {
+			log.Printf("Error reading usage of user %d: %v", userID, err)
+		}
+		cfg := rateLimits[kind]
+		line := fmt.Sprintf("%s: %d", kind, used)
+		if cfg.UserPerDay > 0 {
+			line += fmt.Sprintf(" of %d", cfg.UserPerDay)
+		}
+		if cfg.UserPerHour > 0 {
+			line += fmt.Sprintf(" (max %d per hour)", cfg.UserPerHour)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/api"
+	"pet.outbid.goapp/db"
+)
+
+const (
+	reminderCallbackKey = "remind"
+	// reminderCheckInterval is how often due reminders are looked for.
+	reminderCheckInterval = 30 * time.Second
+	// reminderBatch bounds how many reminders fire per check, e.g. after a
+	// long downtime.
+	reminderBatch = 50
+	// reminderLateAfter is how late a reminder may fire before it says so.
+	reminderLateAfter   = 5 * time.Minute
+	maxRemindersPerUser = 25
+	// minReminderRecurrence keeps recurring reminders from flooding a chat.
+	minReminderRecurrence = time.Hour
+	reminderTimeLayout    = "2006-01-02T15:04"
+	reminderTimeout       = 30 * time.Second
+)
+
+// reminderRequestRe finds "remind me" and "напомни" in a message to the bot.
+var reminderRequestRe = regexp.MustCompile(`(?i)(^|[^\p{L}])(remind me|напомни)`)
+
+// isReminderRequest reports whether a message addressed to the bot asks for
+// a reminder rather than an answer.
+func isReminderRequest(text string) bool {
+	return reminderRequestRe.MatchString(text)
+}
+
+// parsedReminder is the model output for a reminder request.
+type parsedReminder struct {
+	// IsReminder is false for messages like "remind me what we discussed".
+	IsReminder bool   `json:"is_reminder"`
+	Text       string `json:"text"`
+	Time       string `json:"time"`
+	Timezone   string `json:"timezone"`
+	Recurrence string `json:"recurrence"`
+	Error      string `json:"error"`
+}
+
+// handleRemindCommand creates a reminder from /remind <when> <what>.
+func handleRemindCommand(message *tgbotapi.Message) {
+	createReminder(message, message.CommandArguments(), false)
+}
+
+// handleReminderMention creates a reminder from a message like
+// "@bot remind me tomorrow at 10 to call the vet". It returns false when the
+// message turns out not to ask for a reminder and should be answered instead.
+func handleReminderMention(message *tgbotapi.Message, text string) bool {
+	return createReminder(message, strings.TrimSpace(strings.ReplaceAll(text, botUsername, "")), true)
+}
+
+// createReminder has the model extract the time and text of request, stores
+// the reminder and confirms it. With optional set, a request that is no
+// reminder is left alone and false is returned; the answer it gets instead
+// counts against the rate limits, so the request is only counted here once
+// it turns out to be a reminder.
+func createReminder(message *tgbotapi.Message, request string, optional bool) bool {
+	existing, err := db.ListReminders(message.Chat.ID, message.From.ID)
+	if err != nil {
+		log.Printf("Error listing reminders of user %d: %v", message.From.ID, err)
+		replyToCommand(message, "Failed to save the reminder, please try again later.")
+		return true
+	}
+	if len(existing) >= maxRemindersPerUser {
+		replyToCommand(message, fmt.Sprintf("You already have %d reminders here. Cancel some with /reminders first.", len(existing)))
+		return true
+	}
+
+	stop := keepChatAction(message.Chat, tgbotapi.ChatTyping)
+	now := time.Now()
+	parsed, err := extractReminder(request, now)
+	stop()
+	if err != nil {
+		log.Printf("Error extracting reminder from %q: %v", request, err)
+		replyToCommand(message, "I couldn't understand the reminder, please try again later.")
+		return true
+	}
+	if optional {
+		if !parsed.IsReminder {
+			return false
+		}
+		if err := checkRateLimit(message.Chat.ID, message.From.ID, limitKindRequest); err != nil {
+			replyToCommand(message, err.Error())
+			return true
+		}
+	}
+
+	r, err := buildReminder(parsed, now)
+	if err != nil {
+		replyToCommand(message, err.Error())
+		return true
+	}
+	r.ChatID = message.Chat.ID
+	r.UserID = message.From.ID
+	r.MessageID = message.MessageID
+	r.CreatedAt = now
+
+	if r.ID, err = db.AddReminder(r); err != nil {
+		log.Printf("Error saving reminder: %v", err)
+		replyToCommand(message, "Failed to save the reminder, please try again later.")
+		return true
+	}
+
+	text := fmt.Sprintf("⏰ I'll remind you %s: %s", formatReminderTime(r), r.Text)
+	if r.Recurrence != "" {
+		text += "\nRepeats: " + r.Recurrence
+	}
+	replyToCommand(message, text+"\nSee /reminders to cancel.")
+	return true
+}
+
+// extractReminder asks the model for the structured form of request.
+func extractReminder(request string, now time.Time) (parsedReminder, error) {
+	reasoning, verbosity := "low", "low"
+	local := now.In(botLocation)
+	messages := []api.Message{
+		{
+			Role: "system",
+			Content: fmt.Sprintf("You turn reminder requests into JSON. Now it is %s (%s, time zone %s). "+
+				"Reply with a JSON object only, with the keys:\n"+
+				"\"is_reminder\" - false if the message does not ask to be reminded of something at a time "+
+				"(e.g. \"remind me what we discussed\"), else true;\n"+
+				"\"text\" - what to remind about, in the language of the request, without the time;\n"+
+				"\"time\" - the local date and time of the (first) reminder as YYYY-MM-DDTHH:MM;\n"+
+				"\"timezone\" - the IANA time zone if the request names a city or zone, else an empty string;\n"+
+				"\"recurrence\" - for repeating reminders a five-field cron expression (minute hour day month weekday) "+
+				"in that time zone, else an empty string;\n"+
+				"\"error\" - if no time can be understood, a short question asking for it, in the language of the request, "+
+				"else an empty string.\n"+
+				"A time without a date means the next such time. A date without a time means 09:00.",
+				local.Format(reminderTimeLayout), local.Weekday(), botLocation),
+		},
+		{Role: "user", Content: request},
+	}
+
+	resp, err := api.CallChatCompletion(openAIToken, gptModelForChatting, messages,
+		api.ChatOptions{Reasoning: &reasoning, Verbosity: &verbosity, Timeout: reminderTimeout})
+	if err != nil {
+		return parsedReminder{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return parsedReminder{}, fmt.Errorf("no choices in response")
+	}
+
+	content := strings.TrimSpace(messageContentToString(resp.Choices[0].Message.Content))
+	content = strings.TrimPrefix(content, "```json")
+	content = strings.Trim(content, "`\n ")
+
+	var parsed parsedReminder
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		return parsedReminder{}, fmt.Errorf("error decoding %q: %v", content, err)
+	}
+	return parsed, nil
+}
+
+// buildReminder checks the model output. Returned errors are meant to be
+// shown in the chat.
+func buildReminder(parsed parsedReminder, now time.Time) (db.Reminder, error) {
+	if parsed.Error != "" {
+		return db.Reminder{}, errors.New(parsed.Error)
+	}
+
+	location := botLocation
+	if parsed.Timezone != "" {
+		if l, err := time.LoadLocation(parsed.Timezone); err == nil {
+			location = l
+		}
+	}
+	r := db.Reminder{Text: strings.TrimSpace(parsed.Text), Timezone: location.String()}
+	if r.Text == "" {
+		r.Text = "reminder"
+	}
+
+	due, timeErr := time.ParseInLocation(reminderTimeLayout, parsed.Time, location)
+	if parsed.Recurrence != "" {
+		schedule, err := parseCron(parsed.Recurrence, location)
+		if err != nil {
+			return db.Reminder{}, fmt.Errorf("I couldn't understand how often to remind you")
+		}
+		first := schedule.next(now)
+		if first.IsZero() || schedule.next(first).Sub(first) < minReminderRecurrence {
+			return db.Reminder{}, fmt.Errorf("Recurring reminders can repeat at most once an hour")
+		}
+		r.Recurrence = parsed.Recurrence
+		if timeErr != nil || !due.After(now) {
+			due = first
+		}
+	} else if timeErr != nil {
+		return db.Reminder{}, fmt.Errorf("I couldn't understand when to remind you")
+	} else if !due.After(now) {
+		return db.Reminder{}, fmt.Errorf("%s has already passed", due.Format("02.01.2006 15:04"))
+	}
+	r.DueAt = due
+	return r, nil
+}
+
+func reminderLocation(r db.Reminder) *time.Location {
+	if location, err := time.LoadLocation(r.Timezone); err == nil {
+		return location
+	}
+	return botLocation
+}
+
+func formatReminderTime(r db.Reminder) string {
+	return "on " + r.DueAt.In(reminderLocation(r)).Format("Mon 02.01.2006 at 15:04 MST")
+}
+
+// startReminderJob fires due reminders every reminderCheckInterval until
+// shutdown. Reminders missed during downtime fire at the first check.
+func startReminderJob() {
+	ticker := time.NewTicker(reminderCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		done := make(chan struct{})
+		if !goBackground(func() {
+			fireDueReminders(time.Now())
+			close(done)
+		}) {
+			return
+		}
+		<-done
+
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+func fireDueReminders(now time.Time) {
+	due, err := db.GetDueReminders(now, reminderBatch)
+	if err != nil {
+		log.Printf("Error loading due reminders: %v", err)
+		return
+	}
+	for _, r := range due {
+		fireReminder(r, now)
+	}
+}
+
+// fireReminder pings the user in a reply to the message that asked for the
+// reminder, then deletes it or moves it to its next time. A recurring
+// reminder fires once for all the times missed during downtime. A reminder
+// that could not be sent for now is kept and tried again at the next check.
+func fireReminder(r db.Reminder, now time.Time) {
+	location := reminderLocation(r)
+	user := fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, r.UserID, html.EscapeString(resolveUsername(r.ChatID, r.UserID)))
+	text := fmt.Sprintf("⏰ %s, you asked me to remind you: %s", user, html.EscapeString(r.Text))
+	if now.Sub(r.DueAt) > reminderLateAfter {
+		text += fmt.Sprintf("\n<i>This was due %s, sorry for the delay.</i>", r.DueAt.In(location).Format("02.01 15:04"))
+	}
+
+	var next time.Time
+	if r.Recurrence != "" {
+		if schedule, err := parseCron(r.Recurrence, location); err == nil {
+			next = schedule.next(now)
+		}
+		if !next.IsZero() {
+			text += "\nNext time: " + next.Format("Mon 02.01 15:04")
+		}
+	}
+
+	msg := tgbotapi.NewMessage(r.ChatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.ReplyToMessageID = r.MessageID
+	msg.AllowSendingWithoutReply = true
+	sent, err := sendQueued(r.ChatID, msg, priorityReply)
+	switch {
+	case err == nil:
+		saveMessage(&sent, text)
+	case floodRetryAfter(err) > 0 || isTransientSendError(err):
+		// The outbox gave up for now; the reminder stays due.
+		log.Printf("Error sending reminder %d to chat %d, retrying later: %v", r.ID, r.ChatID, err)
+		return
+	default:
+		// The chat is gone or the bot was blocked, the reminder cannot
+		// be delivered anymore.
+		log.Printf("Error sending reminder %d to chat %d, dropping it: %v", r.ID, r.ChatID, err)
+		next = time.Time{}
+	}
+
+	if next.IsZero() {
+		err = db.DeleteReminder(r.ID)
+	} else {
+		err = db.RescheduleReminder(r.ID, next)
+	}
+	if err != nil {
+		log.Printf("Error updating reminder %d: %v", r.ID, err)
+	}
+}
+
+// handleRemindersCommand lists the caller's reminders in this chat with
+// buttons to cancel them.
+func handleRemindersCommand(message *tgbotapi.Message) {
+	text, keyboard := reminderList(message.Chat.ID, message.From.ID)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	sendMessage(msg, false)
+}
+
+// reminderList renders the reminders of a user with one cancel button each.
+func reminderList(chatID int64, userID int64) (string, *tgbotapi.InlineKeyboardMarkup) {
+	reminders, err := db.ListReminders(chatID, userID)
+	if err != nil {
+		log.Printf("Error listing reminders of user %d: %v", userID, err)
+		return "Failed to load the reminders.", nil
+	}
+	if len(reminders) == 0 {
+		return "No reminders. Ask me with /remind or \"" + botUsername + " remind me …\".", nil
+	}
+
+	lines := []string{"Reminders of " + resolveUsername(chatID, userID) + ":"}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, r := range reminders {
+		line := fmt.Sprintf("%d. %s: %s", i+1, r.DueAt.In(reminderLocation(r)).Format("Mon 02.01 15:04 MST"), r.Text)
+		if r.Recurrence != "" {
+			line += " (repeats " + r.Recurrence + ")"
+		}
+		lines = append(lines, line)
+
+		if i%5 == 0 {
+			rows = append(rows, nil)
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("Cancel %d", i+1), callbackData(reminderCallbackKey, "cancel", strconv.FormatInt(r.ID, 10))))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return strings.Join(lines, "\n"), &keyboard
+}
+
+func handleReminderCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 || args[0] != "cancel" {
+		answerCallback(callback, "")
+		return
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		answerCallback(callback, "")
+		return
+	}
+
+	chatID := callback.Message.Chat.ID
+	r, err := db.GetReminder(id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && r.ChatID != chatID) {
+		answerCallback(callback, "This reminder is gone already.")
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading reminder %d: %v", id, err)
+		answerCallback(callback, "")
+		return
+	}
+	if r.UserID != callback.From.ID && !isChatAdmin(chatID, callback.From.ID) {
+		answerCallback(callback, "Only the owner or a chat admin can cancel this.")
+		return
+	}
+	if err := db.DeleteReminder(id); err != nil {
+		log.Printf("Error deleting reminder %d: %v", id, err)
+		answerCallback(callback, "Failed to cancel the reminder.")
+		return
+	}
+
+	text, keyboard := reminderList(chatID, r.UserID)
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+	edit.ReplyMarkup = keyboard
+	if _, err := sendQueued(chatID, edit, priorityReply); err != nil {
+		log.Printf("Error editing reminder list: %v", err)
+	}
+	answerCallback(callback, "Reminder cancelled.")
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+const (
+	retentionPurgeInterval = 1 * time.Hour
+	forgetCallbackKey      = "forget"
+)
+
+// defaultRetention applies to chats without a retention_policies row. It is
+// read from RETENTION_MAX_AGE_DAYS and RETENTION_MAX_ROWS; 0 keeps everything.
+var defaultRetention db.RetentionPolicy
+
+// startRetentionJob purges history that falls outside the retention policy of
+// each known chat, once at start and then every retentionPurgeInterval.
+func startRetentionJob() {
+	ticker := time.NewTicker(retentionPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		done := make(chan struct{})
+		if !goBackground(func() {
+			purgeExpiredMessages()
+			purgeOldUsage()
+			close(done)
+		}) {
+			return
+		}
+		<-done
+
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+func purgeExpiredMessages() {
+	policies, err := db.GetRetentionPolicies()
+	if err != nil {
+		log.Printf("Error loading retention policies: %v", err)
+		return
+	}
+
+	// Every chat with history is covered, including chats that are no longer
+	// in the allowed list.
+	chatIDs, err := db.ListMessageChatIDs()
+	if err != nil {
+		log.Printf("Error listing chats with stored messages: %v", err)
+		return
+	}
+
+	byChat := make(map[int64]db.RetentionPolicy)
+	for _, chatID := range chatIDs {
+		byChat[chatID] = db.RetentionPolicy{ChatID: chatID, MaxAgeDays: defaultRetention.MaxAgeDays, MaxRows: defaultRetention.MaxRows}
+	}
+	for _, p := range policies {
+		byChat[p.ChatID] = p
+	}
+
+	for chatID, p := range byChat {
+		if p.MaxAgeDays <= 0 && p.MaxRows <= 0 {
+			continue
+		}
+
+		var olderThan time.Time
+		if p.MaxAgeDays > 0 {
+			olderThan = time.Now().AddDate(0, 0, -p.MaxAgeDays)
+		}
+		deleted, err := db.PurgeMessages(chatID, olderThan, p.MaxRows)
+		if err != nil {
+			log.Printf("Error purging messages of chat %d: %v", chatID, err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("Retention: purged %d messages from chat %d", deleted, chatID)
+		}
+	}
+}
+
+func effectiveRetentionPolicy(chatID int64) (db.RetentionPolicy, bool) {
+	p, err := db.GetRetentionPolicy(chatID)
+	if err != nil {
+		return db.RetentionPolicy{ChatID: chatID, MaxAgeDays: defaultRetention.MaxAgeDays, MaxRows: defaultRetention.MaxRows}, false
+	}
+	return p, true
+}
+
+func describeRetention(p db.RetentionPolicy) string {
+	age := "unlimited"
+	if p.MaxAgeDays > 0 {
+		age = fmt.Sprintf("%d days", p.MaxAgeDays)
+	}
+	rows := "unlimited"
+	if p.MaxRows > 0 {
+		rows = strconv.Itoa(p.MaxRows)
+	}
+	return fmt.Sprintf("max age: %s, max messages: %s", age, rows)
+}
+
+// handleRetentionCommand shows or changes the chat retention policy:
+// /retention, /retention <days> <rows> (0 = unlimited), /retention default.
+func handleRetentionCommand(message *tgbotapi.Message) {
+	args := strings.Fields(message.CommandArguments())
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyToMessageID = message.MessageID
+		sendMessage(msg, false)
+	}
+
+	if len(args) == 0 {
+		p, custom := effectiveRetentionPolicy(message.Chat.ID)
+		source := "default"
+		if custom {
+			source = "chat override"
+		}
+		reply(fmt.Sprintf("Retention (%s): %s", source, describeRetention(p)))
+		return
+	}
+
+	if !canManageChat(message.Chat.ID, message.From.ID) {
+		reply("Only chat admins can change the retention policy.")
+		return
+	}
+
+	if len(args) == 1 && args[0] == "default" {
+		if err := db.DeleteRetentionPolicy(message.Chat.ID); err != nil {
+			log.Printf("Error deleting retention policy: %v", err)
+			reply("Failed to reset the retention policy.")
+			return
+		}
+		reply("Retention reset to default: " + describeRetention(defaultRetention))
+		return
+	}
+
+	if len(args) != 2 {
+		reply("Usage: /retention <days> <messages> (0 = unlimited) or /retention default")
+		return
+	}
+	days, errDays := strconv.Atoi(args[0])
+	rows, errRows := strconv.Atoi(args[1])
+	if errDays != nil || errRows != nil || days < 0 || rows < 0 {
+		reply("Days and messages must be non-negative numbers.")
+		return
+	}
+
+	p := db.RetentionPolicy{ChatID: message.Chat.ID, MaxAgeDays: days, MaxRows: rows}
+	if err := db.SetRetentionPolicy(p); err != nil {
+		log.Printf("Error saving retention policy: %v", err)
+		reply("Failed to save the retention policy.")
+		return
+	}
+	reply("Retention updated: " + describeRetention(p))
+}
+
+// handleForgetCommand erases stored messages. Users can erase their own
+// history right away; erasing someone else's (/forget @name or as a reply)
+// has to be confirmed by a chat admin.
+func handleForgetCommand(message *tgbotapi.Message) {
+	reply := func(text string) {
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		msg.ReplyToMessageID = message.MessageID
+		sendMessage(msg, false)
+	}
+
+	targetID := message.From.ID
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		userID, found := findUserIDByUsername(arg)
+		if !found {
+			reply(fmt.Sprintf("I don't know user %s.", arg))
+			return
+		}
+		targetID = userID
+	} else if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+		targetID = message.ReplyToMessage.From.ID
+	}
+
+	if targetID == message.From.ID {
+		reply(forgetUser(message.Chat.ID, targetID))
+		return
+	}
+
+	name := resolveUsername(message.Chat.ID, targetID)
+	msg := tgbotapi.NewMessage(message.Chat.ID,
+		fmt.Sprintf("%s asked to erase everything stored from %s. A chat admin has to confirm.",
+			resolveUsername(message.Chat.ID, message.From.ID), name))
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Erase "+name, callbackData(forgetCallbackKey, strconv.FormatInt(targetID, 10))),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", callbackData(forgetCallbackKey, "cancel")),
+	))
+	sendMessage(msg, false)
+}
+
+func handleForgetCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 1 {
+		answerCallback(callback, "")
+		return
+	}
+	chatID := callback.Message.Chat.ID
+	if !canManageChat(chatID, callback.From.ID) {
+		answerCallback(callback, "Only chat admins can confirm this.")
+		return
+	}
+
+	text := "Erase request cancelled."
+	if args[0] != "cancel" {
+		targetID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			answerCallback(callback, "")
+			return
+		}
+		text = forgetUser(chatID, targetID)
+	}
+
+	edit := tgbotapi.NewEditMessageText(chatID, callback.Message.MessageID, text)
+	if _, err := sendQueued(edit.ChatID, edit, priorityReply); err != nil {
+		log.Printf("Error editing forget confirmation: %v", err)
+	}
+	answerCallback(callback, "")
+}
+
+// forgetUser erases the user's stored messages, the bot answers derived from
+// them, the model requests they made, their reminders and cached media, and
+// returns a status line for the chat. Bot answers to other users are kept,
+// even if they quote the user. The profile goes too once no chat holds
+// messages from the user; it is stored again when the user writes next time.
+func forgetUser(chatID int64, userID int64) string {
+	name := resolveUsername(chatID, userID)
+	deleted, err := db.ForgetUser(chatID, userID)
+	if err != nil {
+		log.Printf("Error erasing messages of user %d in chat %d: %v", userID, chatID, err)
+		return "Failed to erase messages, please try again later."
+	}
+	forgetMediaGroupsOfUser(chatID, userID)
+	knownUsersLock.Lock()
+	delete(knownUsers, userID)
+	knownUsersLock.Unlock()
+
+	log.Printf("Erased %d stored messages of user %d in chat %d", deleted, userID, chatID)
+	text := fmt.Sprintf("Erased %d stored messages of %s, with their reminders and requests. "+
+		"My answers to others are kept.", deleted, name)
+	if _, err := db.GetUser(userID); errors.Is(err, db.ErrNotFound) {
+		text += " Their profile is erased too, as no chat holds their messages anymore."
+	}
+	return text
+}
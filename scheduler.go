@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"pet.outbid.goapp/db"
+)
+
+const (
+	schedulerInterval = 30 * time.Second
+	// scheduleRetryDelay is how long a failed run waits before it is tried
+	// again, as long as its slot is still within the catch-up window.
+	scheduleRetryDelay = 5 * time.Minute
+)
+
+// scheduledJob runs for every chat returned by Chats at the times of
+// Schedule. The last slot that ran is stored per chat, so after a restart a
+// slot neither runs twice nor is skipped.
+type scheduledJob struct {
+	Name     string
+	Schedule *cronSchedule
+	// CatchUp is how late a slot missed during downtime may still run;
+	// older slots are dropped. Only the latest missed slot runs.
+	CatchUp time.Duration
+	Chats   func() []int64
+	// Run does the work of one slot. After an error the slot is retried
+	// until it falls out of the catch-up window.
+	Run func(chatID int64, slot time.Time) error
+}
+
+// scheduledJobs is filled in initApp.
+var scheduledJobs []*scheduledJob
+
+type scheduleKey struct {
+	job    string
+	chatID int64
+}
+
+var scheduler = struct {
+	mu      sync.Mutex
+	running map[scheduleKey]bool
+	retryAt map[scheduleKey]time.Time
+}{
+	running: make(map[scheduleKey]bool),
+	retryAt: make(map[scheduleKey]time.Time),
+}
+
+// startScheduler checks for due jobs every schedulerInterval until shutdown.
+func startScheduler() {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		for _, job := range scheduledJobs {
+			for _, chatID := range job.Chats() {
+				runIfDue(job, chatID, now)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-shuttingDown:
+			return
+		}
+	}
+}
+
+// runIfDue starts the job for the chat if a slot passed since its last run.
+func runIfDue(job *scheduledJob, chatID int64, now time.Time) {
+	key := scheduleKey{job.Name, chatID}
+	scheduler.mu.Lock()
+	busy := scheduler.running[key] || scheduler.retryAt[key].After(now)
+	scheduler.mu.Unlock()
+	if busy {
+		return
+	}
+
+	last, err := db.GetScheduleRun(job.Name, chatID)
+	if errors.Is(err, db.ErrNotFound) {
+		// A new job or chat starts with the next slot rather than a past one.
+		if err := db.SetScheduleRun(job.Name, chatID, now); err != nil {
+			log.Printf("Error saving schedule of %s in chat %d: %v", job.Name, chatID, err)
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading schedule of %s in chat %d: %v", job.Name, chatID, err)
+		return
+	}
+
+	slot := job.Schedule.next(last)
+	if slot.IsZero() || slot.After(now) {
+		return
+	}
+	for next := job.Schedule.next(slot); !next.IsZero() && !next.After(now); next = job.Schedule.next(slot) {
+		slot = next
+	}
+	if now.Sub(slot) > job.CatchUp {
+		log.Printf("Scheduler: skipping %s in chat %d, its slot %s is too old", job.Name, chatID, slot.Format(time.RFC3339))
+		if err := db.SetScheduleRun(job.Name, chatID, slot); err != nil {
+			log.Printf("Error saving schedule of %s in chat %d: %v", job.Name, chatID, err)
+		}
+		return
+	}
+
+	scheduler.mu.Lock()
+	scheduler.running[key] = true
+	scheduler.mu.Unlock()
+
+	started := goBackground(func() {
+		err := job.Run(chatID, slot)
+
+		scheduler.mu.Lock()
+		delete(scheduler.running, key)
+		if err != nil {
+			scheduler.retryAt[key] = time.Now().Add(scheduleRetryDelay)
+		} else {
+			delete(scheduler.retryAt, key)
+		}
+		scheduler.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Error running %s in chat %d: %v", job.Name, chatID, err)
+			return
+		}
+		if err := db.SetScheduleRun(job.Name, chatID, slot); err != nil {
+			log.Printf("Error saving schedule of %s in chat %d: %v", job.Name, chatID, err)
+		}
+	})
+	if !started {
+		scheduler.mu.Lock()
+		delete(scheduler.running, key)
+		scheduler.mu.Unlock()
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+const (
+	searchPageSize      = 5
+	searchSnippetBefore = 40
+	searchSnippetAfter  = 80
+	searchCallbackKey   = "search"
+)
+
+var searchMediaTypes = map[string]bool{
+	"photo": true, "video": true, "animation": true, "video_note": true, "sticker": true,
+	"voice": true, "audio": true, "document": true, "link": true,
+}
+
+// handleSearchCommand handles /search <query> [from:@name] [after:YYYY-MM-DD]
+// [before:YYYY-MM-DD] [has:photo|video|document|link|...].
+func handleSearchCommand(message *tgbotapi.Message) {
+	args := strings.TrimSpace(message.CommandArguments())
+	query, err := parseSearchArgs(args)
+	if err != nil {
+		msg := tgbotapi.NewMessage(message.Chat.ID, err.Error())
+		msg.ReplyToMessageID = message.MessageID
+		sendMessage(msg, false)
+		return
+	}
+	query.ChatID = message.Chat.ID
+	query.Limit = searchPageSize
+
+	sessionID := newSearchSession(query, args)
+	text, markup, err := renderSearchPage(sessionID, query, args, 0)
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		text = fmt.Sprintf("Search failed: %v", html.EscapeString(err.Error()))
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	sendMessage(msg, false)
+}
+
+// handleSearchCallback turns the page of an earlier /search result.
+func handleSearchCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 {
+		answerCallback(callback, "")
+		return
+	}
+	page, err := strconv.Atoi(args[1])
+	if err != nil || page < 0 {
+		answerCallback(callback, "")
+		return
+	}
+
+	session := getSearchSession(args[0])
+	if session == nil {
+		answerCallback(callback, "This search has expired, run /search again.")
+		return
+	}
+
+	text, markup, err := renderSearchPage(args[0], session.query, session.label, page)
+	if err != nil {
+		log.Printf("Error searching messages: %v", err)
+		answerCallback(callback, "Search failed, try again later.")
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = markup
+	if _, err := sendQueued(edit.ChatID, edit, priorityReply); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("Error editing search results: %v", err)
+	}
+	answerCallback(callback, "")
+}
+
+func parseSearchArgs(args string) (db.SearchQuery, error) {
+	var query db.SearchQuery
+	var words []string
+
+	for _, token := range strings.Fields(args) {
+		key, value, ok := strings.Cut(token, ":")
+		if !ok || value == "" {
+			words = append(words, token)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			userID, found := findUserIDByUsername(value)
+			if !found {
+				return query, fmt.Errorf("I don't know user %s yet.", value)
+			}
+			query.UserID = userID
+		case "after", "since":
+			date, err := time.ParseInLocation("2006-01-02", value, botLocation)
+			if err != nil {
+				return query, fmt.Errorf("Invalid date %q, use YYYY-MM-DD.", value)
+			}
+			query.After = date
+		case "before", "until":
+			date, err := time.ParseInLocation("2006-01-02", value, botLocation)
+			if err != nil {
+				return query, fmt.Errorf("Invalid date %q, use YYYY-MM-DD.", value)
+			}
+			// Inclusive: the whole "before" day is part of the range.
+			query.Before = date.AddDate(0, 0, 1)
+		case "has":
+			mediaType := strings.ToLower(value)
+			if !searchMediaTypes[mediaType] {
+				return query, fmt.Errorf("Unknown media type %q.", value)
+			}
+			query.MediaType = mediaType
+		default:
+			words = append(words, token)
+		}
+	}
+
+	query.Text = strings.Join(words, " ")
+	if query.Text == "" && query.UserID == 0 && query.MediaType == "" && query.After.IsZero() && query.Before.IsZero() {
+		return query, fmt.Errorf("Please tell me what to search for.")
+	}
+	return query, nil
+}
+
+func newSearchSession(query db.SearchQuery, label string) string {
+	searchSessionsLock.Lock()
+	defer searchSessionsLock.Unlock()
+
+	now := time.Now()
+	for id, session := range searchSessions {
+		if now.Sub(session.updated) > searchSessionTTL {
+			delete(searchSessions, id)
+		}
+	}
+
+	searchSessionSeq++
+	id := strconv.FormatInt(searchSessionSeq, 36)
+	searchSessions[id] = &searchSession{query: query, label: label, updated: now}
+	return id
+}
+
+func getSearchSession(id string) *searchSession {
+	searchSessionsLock.Lock()
+	defer searchSessionsLock.Unlock()
+
+	session := searchSessions[id]
+	if session == nil || time.Since(session.updated) > searchSessionTTL {
+		return nil
+	}
+	session.updated = time.Now()
+	return session
+}
+
+func renderSearchPage(sessionID string, query db.SearchQuery, label string, page int) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	query.Offset = page * searchPageSize
+	messages, total, err := db.SearchMessages(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if total == 0 {
+		return fmt.Sprintf("Nothing found for <i>%s</i>.", html.EscapeString(label)), nil, nil
+	}
+
+	pages := (total + searchPageSize - 1) / searchPageSize
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🔎 %d results for <i>%s</i> (page %d/%d)\n", total, html.EscapeString(label), page+1, pages))
+
+	terms := strings.Fields(query.Text)
+	for i, msg := range messages {
+		date := msg.Date.In(botLocation).Format("02.01.2006 15:04")
+		if link := messageLink(msg.ChatID, msg.MessageID); link != "" {
+			date = `<a href="` + link + `">` + date + `</a>`
+		}
+		sb.WriteString(fmt.Sprintf("\n%d. %s %s: %s\n",
+			query.Offset+i+1, date,
+			html.EscapeString(resolveUsername(msg.ChatID, msg.UserID)),
+			searchSnippet(msg.Text, terms)))
+	}
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("« Prev", callbackData(searchCallbackKey, sessionID, strconv.Itoa(page-1))))
+	}
+	if page+1 < pages {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Next »", callbackData(searchCallbackKey, sessionID, strconv.Itoa(page+1))))
+	}
+	if len(buttons) == 0 {
+		return sb.String(), nil, nil
+	}
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(buttons)
+	return sb.String(), &markup, nil
+}
+
+// searchSnippet cuts the text around the first matching term and highlights
+// it. The result is HTML-escaped.
+func searchSnippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	start, end := 0, 0
+	for _, term := range terms {
+		termRunes := []rune(strings.ToLower(term))
+		if idx := indexRunes(lower, termRunes); idx >= 0 {
+			start, end = idx, idx+len(termRunes)
+			break
+		}
+	}
+
+	from := max(start-searchSnippetBefore, 0)
+	to := min(end+searchSnippetAfter, len(runes))
+
+	var sb strings.Builder
+	if from > 0 {
+		sb.WriteString("…")
+	}
+	sb.WriteString(html.EscapeString(string(runes[from:start])))
+	if end > start {
+		sb.WriteString("<b>" + html.EscapeString(string(runes[start:end])) + "</b>")
+	}
+	sb.WriteString(html.EscapeString(string(runes[end:to])))
+	if to < len(runes) {
+		sb.WriteString("…")
+	}
+	return strings.ReplaceAll(sb.String(), "\n", " ")
+}
+
+func indexRunes(haystack, needle []rune) int {
+	if len(needle) == 0 {
+		return -1
+	}
+	for i := 0; i+len(needle) <= len(haystack); i++ {
+		match := true
+		for j := range needle {
+			if haystack[i+j] != needle[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+// messageLink returns a t.me deep link to a message. Only supergroups and
+// channels (IDs starting with -100) have such links.
+func messageLink(chatID int64, messageID int) string {
+	id := strconv.FormatInt(chatID, 10)
+	if !strings.HasPrefix(id, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(id, "-100"), messageID)
+}
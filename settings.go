@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+const settingsCallbackKey = "settings"
+
+// Values the /settings buttons cycle through.
+var (
+	reasoningLevels = []string{"minimal", "low", "medium", "high"}
+	verbosityLevels = []string{"low", "medium", "high"}
+	historyLimits   = []int{50, 100, 300, 500}
+	searchModes     = []string{"auto", "always", "off"}
+)
+
+// mentionDefaults are the settings used for mentions when a chat has no override.
+func mentionDefaults() db.ChatSettings {
+	return db.ChatSettings{
+		Model:        gptModelForChatting,
+		Reasoning:    "low",
+		Verbosity:    "low",
+		HistoryLimit: 300,
+		SearchMode:   "auto",
+	}
+}
+
+// gptCommandDefaults are the settings used for /gpt when a chat has no override.
+func gptCommandDefaults() db.ChatSettings {
+	d := mentionDefaults()
+	d.Reasoning = "high"
+	d.Verbosity = "medium"
+	return d
+}
+
+// effectiveChatSettings applies the chat override on top of defaults.
+func effectiveChatSettings(chatID int64, defaults db.ChatSettings) db.ChatSettings {
+	s := defaults
+	s.ChatID = chatID
+
+	override, err := db.GetChatSettings(chatID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error loading settings of chat %d: %v", chatID, err)
+		}
+		return s
+	}
+	if override.Model != "" {
+		s.Model = override.Model
+	}
+	if override.Reasoning != "" {
+		s.Reasoning = override.Reasoning
+	}
+	if override.Verbosity != "" {
+		s.Verbosity = override.Verbosity
+	}
+	if override.HistoryLimit > 0 {
+		s.HistoryLimit = override.HistoryLimit
+	}
+	if override.SearchMode != "" {
+		s.SearchMode = override.SearchMode
+	}
+	return s
+}
+
+// handleSettingsCommand shows the chat settings with buttons that cycle
+// through the allowed values.
+func handleSettingsCommand(message *tgbotapi.Message) {
+	text, markup := renderSettings(message.Chat.ID)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = markup
+	sendMessage(msg, false)
+}
+
+func renderSettings(chatID int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	s := effectiveChatSettings(chatID, mentionDefaults())
+	defaults := mentionDefaults()
+
+	mark := func(value, def string) string {
+		if value == def {
+			return value + " (default)"
+		}
+		return value
+	}
+	text := "Chat settings (only chat admins can change them):\n" +
+		"Model: " + mark(s.Model, defaults.Model) + "\n" +
+		"Reasoning: " + mark(s.Reasoning, defaults.Reasoning) + "\n" +
+		"Verbosity: " + mark(s.Verbosity, defaults.Verbosity) + "\n" +
+		"History: " + mark(strconv.Itoa(s.HistoryLimit), strconv.Itoa(defaults.HistoryLimit)) + " messages\n" +
+		"Web search: " + mark(s.SearchMode, defaults.SearchMode)
+
+	button := func(label, field string) []tgbotapi.InlineKeyboardButton {
+		return tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, callbackData(settingsCallbackKey, field)))
+	}
+	markup := tgbotapi.NewInlineKeyboardMarkup(
+		button("Model: "+s.Model, "model"),
+		button("Reasoning: "+s.Reasoning, "reasoning"),
+		button("Verbosity: "+s.Verbosity, "verbosity"),
+		button(fmt.Sprintf("History: %d", s.HistoryLimit), "history"),
+		button("Web search: "+s.SearchMode, "search"),
+		button("Reset to defaults", "reset"),
+	)
+	return text, markup
+}
+
+func handleSettingsCallback(callback *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 1 {
+		answerCallback(callback, "")
+		return
+	}
+	chatID := callback.Message.Chat.ID
+	if !canManageChat(chatID, callback.From.ID) {
+		answerCallback(callback, "Only chat admins can change settings.")
+		return
+	}
+
+	override, err := db.GetChatSettings(chatID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		log.Printf("Error loading settings of chat %d: %v", chatID, err)
+		answerCallback(callback, "Failed to load settings.")
+		return
+	}
+	current := effectiveChatSettings(chatID, mentionDefaults())
+	defaults := mentionDefaults()
+
+	// A value equal to the default is stored as empty, so the chat keeps
+	// following the global default if it changes later.
+	orDefault := func(value, def string) string {
+		if value == def {
+			return ""
+		}
+		return value
+	}
+
+	switch args[0] {
+	case "model":
+		override.Model = orDefault(nextValue(settingsModels, current.Model), defaults.Model)
+	case "reasoning":
+		override.Reasoning = orDefault(nextValue(reasoningLevels, current.Reasoning), defaults.Reasoning)
+	case "verbosity":
+		override.Verbosity = orDefault(nextValue(verbosityLevels, current.Verbosity), defaults.Verbosity)
+	case "history":
+		limit := historyLimits[0]
+		for i, l := range historyLimits {
+			if l == current.HistoryLimit {
+				limit = historyLimits[(i+1)%len(historyLimits)]
+			}
+		}
+		override.HistoryLimit = limit
+		if limit == defaults.HistoryLimit {
+			override.HistoryLimit = 0
+		}
+	case "search":
+		override.SearchMode = orDefault(nextValue(searchModes, current.SearchMode), defaults.SearchMode)
+	case "reset":
+		override = db.ChatSettings{}
+	default:
+		answerCallback(callback, "")
+		return
+	}
+
+	override.ChatID = chatID
+	override.UpdatedBy = callback.From.ID
+	override.UpdatedAt = time.Now()
+	if override.Model == "" && override.Reasoning == "" && override.Verbosity == "" &&
+		override.HistoryLimit == 0 && override.SearchMode == "" {
+		err = db.DeleteChatSettings(chatID)
+	} else {
+		err = db.SetChatSettings(override)
+	}
+	if err != nil {
+		log.Printf("Error saving settings of chat %d: %v", chatID, err)
+		answerCallback(callback, "Failed to save settings.")
+		return
+	}
+
+	text, markup := renderSettings(chatID)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, callback.Message.MessageID, text, markup)
+	if _, err := sendQueued(edit.ChatID, edit, priorityReply); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		log.Printf("Error editing settings message: %v", err)
+	}
+	answerCallback(callback, "")
+}
+
+// nextValue returns the value after current in values, wrapping around.
+func nextValue(values []string, current string) string {
+	for i, v := range values {
+		if v == current {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+// initSettingsModels collects the models offered in /settings: the
+// configured chat models plus the optional comma separated CHAT_MODELS list.
+func initSettingsModels() {
+	seen := make(map[string]bool)
+	candidates := append([]string{gptModelForChatting, gptModelForGptCommand}, strings.Split(os.Getenv("CHAT_MODELS"), ",")...)
+	for _, model := range candidates {
+		model = strings.TrimSpace(model)
+		if model == "" || seen[model] {
+			continue
+		}
+		seen[model] = true
+		settingsModels = append(settingsModels, model)
+	}
+}
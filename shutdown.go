@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"pet.outbid.goapp/db"
+)
+
+// shutdownGrace is how long in-flight updates and background tasks may run
+// after SIGTERM/SIGINT. Read from SHUTDOWN_GRACE_SECONDS.
+var shutdownGrace = 25 * time.Second
+
+// shuttingDown is closed once a shutdown signal was received; the webhook
+// handler uses it to turn Telegram away instead of queueing more work.
+var (
+	shuttingDown     = make(chan struct{})
+	shuttingDownOnce sync.Once
+)
+
+// backgroundTasks tracks work started outside the update workers (such as
+// summaries of long answers) so shutdown can wait for it. Once shutdown
+// waits for them, backgroundClosed is set and no new tasks are started: a
+// WaitGroup must not grow while it is waited for.
+var (
+	backgroundTasks  sync.WaitGroup
+	backgroundLock   sync.Mutex
+	backgroundClosed bool
+)
+
+// goBackground runs fn in a goroutine tracked by backgroundTasks. It reports
+// false, without running fn, when shutdown no longer accepts new tasks.
+func goBackground(fn func()) bool {
+	backgroundLock.Lock()
+	defer backgroundLock.Unlock()
+
+	if backgroundClosed {
+		return false
+	}
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		fn()
+	}()
+	return true
+}
+
+// closeBackground stops goBackground from starting new tasks.
+func closeBackground() {
+	backgroundLock.Lock()
+	backgroundClosed = true
+	backgroundLock.Unlock()
+}
+
+func beginShutdown() {
+	shuttingDownOnce.Do(func() { close(shuttingDown) })
+}
+
+// waitTimeout waits for wg until deadline and reports whether it finished.
+func waitTimeout(wg *sync.WaitGroup, deadline time.Time) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// shutdown runs after handleUpdates returned: it waits for background
+// tasks, stops the web server, flushes pending writes and closes the store.
+// Updates still running past the deadline can't start background tasks.
+func shutdown(deadline time.Time, webServer *http.Server) {
+	closeBackground()
+	if !waitTimeout(&backgroundTasks, deadline) {
+		log.Println("Shutdown: background tasks did not finish in time, abandoning them")
+	}
+
+	if webServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := webServer.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: error stopping web server: %v", err)
+		}
+		cancel()
+	}
+
+	if err := db.Close(); err != nil {
+		log.Printf("Shutdown: error closing database: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/api"
+	"pet.outbid.goapp/db"
+)
+
+const (
+	// defaultSummaryMessages is used by /summarize without arguments.
+	defaultSummaryMessages = 100
+	// maxSummaryMessages bounds how many messages one summary reads.
+	maxSummaryMessages = 3000
+	// summaryPageSize is how many messages are read from the store at once.
+	summaryPageSize = 500
+	// summaryChunkChars keeps every model request well under the context
+	// limit; longer ranges are summarized chunk by chunk and then merged.
+	summaryChunkChars = 40000
+)
+
+// summaryRange is a parsed /summarize argument: the last Count messages,
+// everything since Since, or everything after the caller's last message.
+// Until, when set, ends the range before that time.
+type summaryRange struct {
+	Count            int
+	Since            time.Time
+	Until            time.Time
+	SinceLastMessage bool
+	// AfterMessageID is the caller's last message once it was looked up.
+	AfterMessageID int
+	Description    string
+}
+
+var summaryDurationRe = regexp.MustCompile(`^(\d+)\s*([mhd])$`)
+
+// parseSummaryRange parses "N", "2h"/"30m"/"3d", "today" or
+// "since-my-last-message".
+func parseSummaryRange(args string, now time.Time) (summaryRange, error) {
+	args = strings.ToLower(strings.TrimSpace(args))
+	switch args {
+	case "":
+		return summaryRange{Count: defaultSummaryMessages,
+			Description: fmt.Sprintf("the last %d messages", defaultSummaryMessages)}, nil
+	case "today":
+		y, m, d := now.Date()
+		return summaryRange{Since: time.Date(y, m, d, 0, 0, 0, 0, now.Location()), Description: "today"}, nil
+	case "since-my-last-message", "since-my-last", "since":
+		return summaryRange{SinceLastMessage: true, Description: "since your last message"}, nil
+	}
+
+	if n, err := strconv.Atoi(args); err == nil {
+		if n < 1 || n > maxSummaryMessages {
+			return summaryRange{}, fmt.Errorf("I can summarize 1 to %d messages", maxSummaryMessages)
+		}
+		return summaryRange{Count: n, Description: fmt.Sprintf("the last %d messages", n)}, nil
+	}
+
+	if m := summaryDurationRe.FindStringSubmatch(args); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[m[2]]
+		if n < 1 || time.Duration(n)*unit > 7*24*time.Hour {
+			return summaryRange{}, fmt.Errorf("I can summarize up to 7 days")
+		}
+		return summaryRange{Since: now.Add(-time.Duration(n) * unit), Description: "the last " + m[1] + m[2]}, nil
+	}
+	return summaryRange{}, fmt.Errorf("I don't understand %q", args)
+}
+
+func argsSummaryRange(args string) error {
+	_, err := parseSummaryRange(args, time.Now())
+	return err
+}
+
+// handleSummarizeCommand replies with a digest of a range of the chat.
+func handleSummarizeCommand(message *tgbotapi.Message) {
+	r, _ := parseSummaryRange(message.CommandArguments(), time.Now())
+	chatID := message.Chat.ID
+
+	reader := ""
+	if r.SinceLastMessage {
+		last, total, err := db.SearchMessages(db.SearchQuery{ChatID: chatID, UserID: message.From.ID, Limit: 1})
+		if err != nil {
+			log.Printf("Error finding last message of user %d: %v", message.From.ID, err)
+			replyToCommand(message, "Failed to load the chat history.")
+			return
+		}
+		if total == 0 {
+			replyToCommand(message, "I haven't seen any message from you here yet. Try /summarize today.")
+			return
+		}
+		r.Since = last[0].Date
+		r.AfterMessageID = last[0].MessageID
+		reader = resolveUsername(chatID, message.From.ID)
+	}
+
+	messages, err := loadSummaryRange(chatID, r)
+	if err != nil {
+		log.Printf("Error loading messages to summarize in chat %d: %v", chatID, err)
+		replyToCommand(message, "Failed to load the chat history.")
+		return
+	}
+	if len(messages) == 0 {
+		replyToCommand(message, "Nothing to summarize for "+r.Description+".")
+		return
+	}
+
+	progress := startProgress(message, tgbotapi.ChatTyping)
+	settings := effectiveChatSettings(chatID, mentionDefaults())
+	digest, err := summarizeMessages(chatID, messages, r.Description, reader, settings.Model)
+	if err != nil {
+		progress.dismiss()
+		log.Printf("Error summarizing chat %d: %v", chatID, err)
+		replyToCommand(message, "Failed to summarize the chat, please try again later.")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, digest)
+	msg.ParseMode = tgbotapi.ModeMarkdownV2
+	progress.deliver(msg, settings.Model)
+}
+
+// loadSummaryRange returns the messages of a range in chronological order,
+// at most maxSummaryMessages of the newest ones.
+func loadSummaryRange(chatID int64, r summaryRange) ([]db.Message, error) {
+	if r.Count > 0 {
+		return db.GetLastMessages(chatID, r.Count)
+	}
+
+	var messages []db.Message
+	for len(messages) < maxSummaryMessages {
+		page, _, err := db.SearchMessages(db.SearchQuery{
+			ChatID: chatID,
+			After:  r.Since,
+			Before: r.Until,
+			Limit:  summaryPageSize,
+			Offset: len(messages),
+		})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page...)
+		if len(page) < summaryPageSize {
+			break
+		}
+	}
+	if len(messages) > maxSummaryMessages {
+		messages = messages[:maxSummaryMessages]
+	}
+
+	// SearchMessages returns the newest first and includes the caller's
+	// own last message.
+	kept := messages[:0]
+	for _, msg := range messages {
+		if msg.MessageID > r.AfterMessageID {
+			kept = append(kept, msg)
+		}
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].MessageID < kept[j].MessageID })
+	return kept, nil
+}
+
+// summaryDigestFormat is the structure every digest follows.
+const summaryDigestFormat = "Write the digest in the language most of the chat uses, in markdown, with these sections:\n" +
+	"**Topics** - the main threads of discussion, one bullet each\n" +
+	"**Decisions** - what was agreed or decided\n" +
+	"**Open questions** - questions left without an answer\n" +
+	"**Who said what** - the key contributions of each active participant\n" +
+	"Leave out a section that would be empty. Be concise and do not invent anything."
+
+// summarizeMessages writes a digest of messages covering period. Long ranges
+// are cut into chunks whose notes are merged, so no request grows past the
+// context limit. With reader set the digest focuses on what matters to them.
+func summarizeMessages(chatID int64, messages []db.Message, period string, reader string, model string) (string, error) {
+	chunks := summaryChunks(chatID, messages)
+
+	var notes []string
+	if len(chunks) == 1 {
+		notes = chunks
+	} else {
+		for i, chunk := range chunks {
+			note, err := summaryCompletion(model,
+				fmt.Sprintf("You take notes on part %d of %d of a group chat log. List the topics, decisions, "+
+					"open questions and the key points of each participant, with names. Be brief, keep facts.", i+1, len(chunks)),
+				chunk)
+			if err != nil {
+				return "", fmt.Errorf("error summarizing chunk %d: %v", i+1, err)
+			}
+			notes = append(notes, note)
+		}
+		// Notes of very long ranges may still not fit; merge them in rounds.
+		for len(notes) > 1 && len(strings.Join(notes, "\n\n")) > summaryChunkChars {
+			merged, err := mergeSummaryNotes(model, notes)
+			if err != nil {
+				return "", err
+			}
+			notes = merged
+		}
+	}
+
+	system := "You write a digest of a Telegram group chat covering " + period + ". " + summaryDigestFormat
+	if reader != "" {
+		system += fmt.Sprintf("\nThe digest is for %s, who was away. Start with what concerns them directly: "+
+			"mentions, replies to them and questions addressed to them.", reader)
+	}
+	input := strings.Join(notes, "\n\n")
+	if len(chunks) > 1 {
+		input = "Notes taken on consecutive parts of the chat:\n\n" + input
+	}
+	return summaryCompletion(model, system, input)
+}
+
+// mergeSummaryNotes condenses notes pairwise, halving their number.
+func mergeSummaryNotes(model string, notes []string) ([]string, error) {
+	var merged []string
+	for i := 0; i < len(notes); i += 2 {
+		if i+1 == len(notes) {
+			merged = append(merged, notes[i])
+			break
+		}
+		note, err := summaryCompletion(model,
+			"Merge these notes on two consecutive parts of a group chat into one set of notes. "+
+				"Keep topics, decisions, open questions and who said what. Be brief.",
+			notes[i]+"\n\n"+notes[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("error merging notes: %v", err)
+		}
+		merged = append(merged, note)
+	}
+	return merged, nil
+}
+
+// summaryChunks renders messages as log lines grouped into chunks of at most
+// summaryChunkChars.
+func summaryChunks(chatID int64, messages []db.Message) []string {
+	var chunks []string
+	var sb strings.Builder
+	for _, msg := range messages {
+		text := msg.Text
+		if msg.AggregatedText != nil && *msg.AggregatedText != "" {
+			text = *msg.AggregatedText
+		}
+		if strings.TrimSpace(text) == "" && msg.MediaType != "" {
+			text = "[" + msg.MediaType + "]"
+		}
+		line := fmt.Sprintf("%s %s: %s\n", msg.Date.Local().Format("02.01 15:04"), resolveUsername(chatID, msg.UserID), text)
+		if sb.Len() > 0 && sb.Len()+len(line) > summaryChunkChars {
+			chunks = append(chunks, sb.String())
+			sb.Reset()
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() > 0 {
+		chunks = append(chunks, sb.String())
+	}
+	return chunks
+}
+
+func summaryCompletion(model string, system string, input string) (string, error) {
+	reasoning, verbosity := "low", "medium"
+	messages := []api.Message{
+		{Role: "system", Content: system},
+		{Role: "user", Content: input},
+	}
+	resp, err := api.CallChatCompletion(openAIToken, model, messages,
+		api.ChatOptions{Reasoning: &reasoning, Verbosity: &verbosity})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices in summary response")
+	}
+	text := strings.TrimSpace(messageContentToString(resp.Choices[0].Message.Content))
+	if text == "" {
+		return "", fmt.Errorf("empty summary response")
+	}
+	return text, nil
+}
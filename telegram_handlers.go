@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,62 +15,135 @@ import (
 	"pet.outbid.goapp/db"
 )
 
-func handleUpdates() {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-
-	updates := bot.GetUpdatesChan(u)
-
-	const workerCount = 5
-	jobs := make(chan tgbotapi.Update, 100)
-
-	for i := 0; i < workerCount; i++ {
-		go worker(bot, jobs)
+// handleUpdates feeds updates to the dispatcher until ctx is cancelled, then
+// lets the queued updates finish within shutdownGrace and returns the
+// deadline the rest of the shutdown has to meet.
+func handleUpdates(ctx context.Context) time.Time {
+	updates := updatesChannel()
+
+	abandoned := 0
+receive:
+	for {
+		select {
+		case <-ctx.Done():
+			break receive
+		case update := <-updates:
+			if update.InlineQuery != nil {
+				query := update.InlineQuery
+				goBackground(func() { handleInlineQuery(query) })
+				continue
+			}
+			if update.Message == nil && update.EditedMessage == nil && update.CallbackQuery == nil && update.MyChatMember == nil {
+				continue
+			}
+			if !dispatcher.dispatch(update, ctx.Done()) {
+				abandoned++
+				break receive
+			}
+		}
 	}
 
-	for update := range updates {
-		if update.Message != nil {
-			jobs <- update
-		}
+	deadline := time.Now().Add(shutdownGrace)
+	// Updates already received but not handed to the dispatcher are lost.
+	abandoned += len(updates)
+	queued := 0
+	for _, depth := range dispatcher.queueDepths() {
+		queued += depth
+	}
+	log.Printf("Shutting down: %d updates queued, %d in progress, waiting up to %s", queued, dispatcher.inFlight.Load(), shutdownGrace)
+	beginShutdown()
+	if updateMode == updateModePolling {
+		bot.StopReceivingUpdates()
 	}
-}
 
-func worker(bot *tgbotapi.BotAPI, jobs <-chan tgbotapi.Update) {
-	for update := range jobs {
-		handleUpdate(bot, update)
+	abandoned += dispatcher.wait(deadline)
+	if abandoned > 0 {
+		log.Printf("Shutdown: abandoned %d queued updates", abandoned)
 	}
+	return deadline
 }
 
 func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
-	if update.Message.Chat.ID != allowedChatID && update.Message.Chat.ID != testChatID {
-		alertMsg := tgbotapi.NewMessage(adminChatID, fmt.Sprintf("Unauthorized access attempt from chat ID: %d", update.Message.Chat.ID))
-		sendMessage(alertMsg, false)
-		if update.Message.Chat.IsPrivate() {
-			fmt.Println("Private chat message, continue")
+	if update.MyChatMember != nil {
+		handleMyChatMember(update.MyChatMember)
+		return
+	}
+
+	if update.CallbackQuery != nil {
+		callback := update.CallbackQuery
+		observeUser(callback.From)
+		if callback.Message == nil || !canUseChat(callback.Message.Chat, callback.From) {
+			answerCallback(callback, "")
 			return
 		}
-		log.Printf("Message from not allowed chat: %d, text: %s", update.Message.Chat.ID, update.Message.Text)
-		leaveChat := tgbotapi.LeaveChatConfig{
-			ChatID: update.Message.Chat.ID,
-		}
-		if _, err := bot.Request(leaveChat); err != nil {
-			log.Printf("Failed to leave unauthorized chat: %v", err)
-		} else {
-			log.Printf("Left unauthorized chat ID: %d", update.Message.Chat.ID)
-		}
+		handleCallbackQuery(callback)
+		return
+	}
+
+	message := update.Message
+	if message == nil {
+		message = update.EditedMessage
+	}
+
+	if !canUseChat(message.Chat, message.From) && !isChatAccessCommand(message) {
+		handleUnknownChat(message)
 		return
 	}
 
-	if update.Message.IsCommand() {
-		handleCommand(update.Message)
+	observeUser(message.From)
+	if message.ReplyToMessage != nil {
+		observeUser(message.ReplyToMessage.From)
+	}
+
+	if update.EditedMessage != nil {
+		handleEditedMessage(message)
+	} else if message.IsCommand() {
+		handleCommand(message)
 	} else {
-		handleMessage(update.Message)
+		handleMessage(message)
+	}
+}
+
+// isChatAdmin reports whether userID administers chatID. The bot admin (the
+// owner of ADMIN_CHAT_ID) counts as an admin everywhere.
+func isChatAdmin(chatID int64, userID int64) bool {
+	if userID == adminChatID {
+		return true
 	}
+
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatID,
+			UserID: userID,
+		},
+	})
+	if err != nil {
+		log.Printf("Error checking admin status of user %d in chat %d: %v", userID, chatID, err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+// canManageChat reports whether userID may change what is stored for chatID:
+// chat admins, and users in their own private chat with the bot.
+func canManageChat(chatID int64, userID int64) bool {
+	return chatID == userID || isChatAdmin(chatID, userID)
 }
 
 func handleMessage(message *tgbotapi.Message) {
 	recordMediaGroup(message)
 
+	// In private chats every message is addressed to the bot. Album photos
+	// without a caption are only kept for the captioned one.
+	if message.Chat.IsPrivate() {
+		if message.Text == "" && message.Caption == "" && (message.MediaGroupID != "" || !hasSupportedMedia(message)) {
+			saveMessage(message)
+			return
+		}
+		handleMention(message)
+		return
+	}
+
 	var text string
 	if message.Text != "" {
 		text = message.Text
@@ -81,12 +156,14 @@ func handleMessage(message *tgbotapi.Message) {
 			// If it's a reply to the bot's message, handle it as a bot mention (including the media)
 			handleMention(message)
 		} else {
-			log.Printf("Received media without text (message_id: %d), ignoring.", message.MessageID)
+			// Kept so /search has:photo finds it
+			saveMessage(message)
 		}
 		return
 	} else {
-		// No text, no caption, no photo: ignore other non-text messages (stickers, etc.)
-		log.Printf("Received non-text message without caption (message_id: %d), ignoring.", message.MessageID)
+		// No text, no caption, no photo: other media (voice, video, …) is
+		// kept for /search, service messages are dropped by storeMessage
+		saveMessage(message)
 		return
 	}
 
@@ -103,71 +180,135 @@ func isBotMentioned(text string) bool {
 	return strings.Contains(text, botUsername)
 }
 
-func sendMessage(msg tgbotapi.MessageConfig, saveOptions ...bool) {
-	originalText := msg.Text
+// sendMessage delivers msg and returns the sent message, or nil on failure.
+// Texts over Telegram's limit are split into several messages, of which the
+// first one replies to msg.ReplyToMessageID and carries the keyboard and is
+// returned; very long or code-heavy answers are sent as a file instead.
+func sendMessage(msg tgbotapi.MessageConfig, saveOptions ...bool) *tgbotapi.Message {
 	save := true
 	if len(saveOptions) > 0 {
 		save = saveOptions[0]
 	}
+	return deliverMessage(msg, save, priorityReply)
+}
 
-	const longMsgThreshold = 300
+// sendBackgroundMessage is sendMessage for posts nobody is waiting for, such
+// as alerts; replies to users are sent first.
+func sendBackgroundMessage(msg tgbotapi.MessageConfig, save bool) *tgbotapi.Message {
+	return deliverMessage(msg, save, priorityBackground)
+}
+
+func deliverMessage(msg tgbotapi.MessageConfig, save bool, priority sendPriority) *tgbotapi.Message {
+	originalText := msg.Text
+
+	var first *tgbotapi.Message
+	var partIDs []int
+	parts := splitMessageText(originalText, telegramTextLimit)
+	if sendAsFile(originalText, parts) {
+		first = sendAnswerFile(msg, priority)
+	} else {
+		for i, part := range parts {
+			partMsg := msg
+			partMsg.Text = part
+			if i > 0 {
+				partMsg.ReplyToMessageID = 0
+				partMsg.ReplyMarkup = nil
+			}
+			sent := sendMessagePart(partMsg, priority)
+			if sent == nil {
+				break
+			}
+			if first == nil {
+				first = sent
+			} else {
+				partIDs = append(partIDs, sent.MessageID)
+			}
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	// The follow-up parts are remembered so editBotMessage can replace them.
+	if len(partIDs) > 0 {
+		if err := db.SetMessageParts(first.Chat.ID, first.MessageID, partIDs); err != nil {
+			log.Printf("Error saving parts of message %d: %v", first.MessageID, err)
+		}
+	}
 
+	if save {
+		saveMessage(first, originalText)
+	}
+	return first
+}
+
+// sendMessagePart sends one message that fits Telegram's limit.
+func sendMessagePart(msg tgbotapi.MessageConfig, priority sendPriority) *tgbotapi.Message {
+	originalText := msg.Text
 	if msg.ParseMode == tgbotapi.ModeMarkdownV2 {
 		msg.ParseMode = tgbotapi.ModeHTML
-		formatted := formatHTML(originalText)
-		if utf8.RuneCountInString(originalText) > longMsgThreshold {
-			// Try Telegram's expandable blockquote entity via HTML.
-			msg.Text = `<blockquote expandable="true">` + formatted + `</blockquote>`
-		} else {
-			msg.Text = formatted
-		}
+		msg.Text = renderAnswerHTML(originalText)
 	}
 
-	newMessage, err := bot.Send(msg)
+	newMessage, err := sendQueued(msg.ChatID, msg, priority)
 	if err != nil {
 		// If markdown parsing failed – retry without formatting
 		if strings.Contains(err.Error(), "can't parse entities") {
 			log.Printf("Markdown parse error: %v, retrying without parse_mode", err)
 			msg.ParseMode = ""
 			msg.Text = originalText
-			newMessage, err = bot.Send(msg)
+			newMessage, err = sendQueued(msg.ChatID, msg, priority)
 		}
 	}
 
 	if err != nil {
 		log.Printf("Error sending message: %v", err)
-		_, _ = bot.Send(tgbotapi.NewMessage(msg.ChatID,
-			fmt.Sprintf("Error sending message: %v", err)))
-		return
+		_, _ = sendQueued(msg.ChatID, tgbotapi.NewMessage(msg.ChatID,
+			fmt.Sprintf("Error sending message: %v", err)), priority)
+		return nil
 	}
+	return &newMessage
+}
 
-	if save {
-		saveMessage(&newMessage, originalText)
+// sendAnswerFile sends the text of msg as a document. The inline keyboard is
+// dropped because a document cannot be edited into a new answer.
+func sendAnswerFile(msg tgbotapi.MessageConfig, priority sendPriority) *tgbotapi.Message {
+	name, content := answerFile(msg.Text)
+	doc := tgbotapi.NewDocument(msg.ChatID, tgbotapi.FileBytes{Name: name, Bytes: content})
+	doc.Caption = answerFileCaption(msg.Text)
+	doc.ReplyToMessageID = msg.ReplyToMessageID
+
+	newMessage, err := sendQueued(msg.ChatID, doc, priority)
+	if err != nil {
+		log.Printf("Error sending %s: %v", name, err)
+		_, _ = sendQueued(msg.ChatID, tgbotapi.NewMessage(msg.ChatID,
+			fmt.Sprintf("Error sending message: %v", err)), priority)
+		return nil
 	}
+	return &newMessage
 }
 
-func handleCommand(message *tgbotapi.Message) {
-	switch message.Command() {
-	case "help":
-		handleHelpCommand(message)
-	case "getinfo":
-		handleGetInfoCommand(message)
-	case "gpt":
-		handleGptCommand(message)
-	default:
-		handleUnknownCommand(message)
+// renderAnswerHTML converts a model answer to Telegram HTML, folding long
+// answers into an expandable blockquote.
+func renderAnswerHTML(text string) string {
+	const longMsgThreshold = 300
+
+	formatted := formatHTML(text)
+	if utf8.RuneCountInString(text) > longMsgThreshold {
+		// Try Telegram's expandable blockquote entity via HTML.
+		return `<blockquote expandable="true">` + formatted + `</blockquote>`
 	}
+	return formatted
 }
 
-func handleHelpCommand(message *tgbotapi.Message) {
-	helpText := "Available commands:\n" +
-		"/help - List available commands\n" +
-		"/getinfo - Get your account information\n" +
-		"/gpt - Forward message to gpt\n" +
-		"Tag me @buddy_bro_pet_bot if you want to chat with me\n" +
-		"Если использовать \"загугли\", \"поищи\" или ссылку в сообщении, то будет веб поиск(очень долго думает секунд 30-60)"
-	msg := tgbotapi.NewMessage(message.Chat.ID, helpText)
-	sendMessage(msg, false)
+// sendReply sends a model answer to trigger and stores it together with the
+// model name and the triggering message. It returns the sent message or nil.
+func sendReply(msg tgbotapi.MessageConfig, trigger *tgbotapi.Message, model string) *tgbotapi.Message {
+	msg.ReplyToMessageID = trigger.MessageID
+	sent := sendMessage(msg, false)
+	if sent != nil {
+		saveBotReply(sent, msg.Text, model, trigger.MessageID)
+	}
+	return sent
 }
 
 func handleGetInfoCommand(message *tgbotapi.Message) {
@@ -189,15 +330,9 @@ func handleGetInfoCommand(message *tgbotapi.Message) {
 
 func handleGptCommand(message *tgbotapi.Message) {
 	args := message.CommandArguments()
-	if args == "" {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Please provide a message for GPT.")
-		sendMessage(msg, false)
-		return
-	}
 	saveMessage(message, args)
 
-	reasoning := "high"
-	verbosity := "medium"
+	settings := effectiveChatSettings(message.Chat.ID, gptCommandDefaults())
 	messages := []api.Message{
 		{
 			Role:    "system",
@@ -209,13 +344,15 @@ func handleGptCommand(message *tgbotapi.Message) {
 		},
 	}
 
+	progress := startProgress(message, tgbotapi.ChatTyping)
 	completionResponse, err := api.CallChatCompletion(
 		openAIToken,
-		gptModelForChatting,
+		settings.Model,
 		messages,
-		api.ChatOptions{Reasoning: &reasoning, Verbosity: &verbosity},
+		api.ChatOptions{Reasoning: &settings.Reasoning, Verbosity: &settings.Verbosity},
 	)
 	if err != nil {
+		progress.dismiss()
 		fmt.Printf("Error getting chat completion: %v\n", err)
 		return
 	}
@@ -227,19 +364,56 @@ func handleGptCommand(message *tgbotapi.Message) {
 		txt = "No choices in response"
 	}
 	msg := tgbotapi.NewMessage(message.Chat.ID, txt)
-	msg.ReplyToMessageID = message.MessageID
 	msg.ParseMode = tgbotapi.ModeMarkdownV2
-	sendMessage(msg)
+	progress.deliver(msg, settings.Model)
 }
 
 func handleMention(message *tgbotapi.Message) {
+	saveMessage(message)
+
+	if text := message.Text + message.Caption; isReminderRequest(text) && handleReminderMention(message, text) {
+		return
+	}
+
+	progress := startProgress(message, mentionChatAction(message))
+	answer, err := generateMentionAnswer(message)
+	if err != nil {
+		progress.dismiss()
+		log.Printf("Error answering mention %d: %v", message.MessageID, err)
+		msg := tgbotapi.NewMessage(message.Chat.ID, err.Error())
+		msg.ReplyToMessageID = message.MessageID
+		sendMessage(msg, false)
+		return
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, answer.Text)
+	msg.ParseMode = tgbotapi.ModeMarkdownV2
+	msg.ReplyMarkup = answerKeyboard()
+	if sent := progress.deliver(msg, answer.Model); sent != nil {
+		saveAnswerRequest(message, sent.MessageID, answer)
+	}
+}
+
+// mentionAnswer is a model answer together with what is needed to rebuild
+// the request, so the answer can be regenerated from the inline buttons.
+type mentionAnswer struct {
+	Text         string
+	Model        string
+	Question     string
+	Media        []mediaItem
+	HistoryLimit int
+	Reasoning    *string
+	Verbosity    *string
+}
+
+// generateMentionAnswer builds the prompt for a message addressed to the bot
+// and asks the model. Returned errors are meant to be shown in the chat.
+func generateMentionAnswer(message *tgbotapi.Message) (*mentionAnswer, error) {
 	text := message.Text
 	if text == "" && message.Caption != "" {
 		text = message.Caption
 	}
 
-	saveMessage(message)
-
 	// If replying to a message, prepend context info to the user text as before
 	if message.ReplyToMessage != nil {
 		replyMessageId := message.ReplyToMessage.MessageID
@@ -257,17 +431,87 @@ func handleMention(message *tgbotapi.Message) {
 		}
 	}
 
-	mediaMessages := collectMediaMessages(message)
+	media := collectMediaItems(message)
+
+	// Determine which model to use (web search or normal) based on triggers
+	replyContext := ""
+	if message.ReplyToMessage != nil {
+		replyContext = message.ReplyToMessage.Text
+	}
+
+	settings := effectiveChatSettings(message.Chat.ID, mentionDefaults())
+	var useSearchModel bool
+	switch settings.SearchMode {
+	case "always":
+		useSearchModel = true
+	case "off":
+		useSearchModel = false
+	default:
+		useSearchModel = shouldUseWebSearch(text, replyContext)
+	}
+	fmt.Printf("useSearchModel: %v\n", useSearchModel)
+	modelName := settings.Model
+	if useSearchModel && len(media) == 0 {
+		modelName = gptModelForWebSearch
+	}
+	// The search model does not accept reasoning/verbosity options
+	var reasoning *string
+	var verbosity *string
+	if !useSearchModel {
+		verbosity, reasoning = &settings.Verbosity, &settings.Reasoning
+	}
+
+	limit := settings.HistoryLimit
+	if useSearchModel {
+		limit = 10
+	}
+
+	kinds := requestKinds(modelName == gptModelForWebSearch, len(media) > 0)
+	if err := checkRateLimit(message.Chat.ID, message.From.ID, kinds...); err != nil {
+		return nil, err
+	}
+
+	messages, err := buildMentionMessages(message.Chat.ID, message.Chat.IsPrivate(), message.MessageID, text, media, limit)
+	if err != nil {
+		return nil, err
+	}
 
+	completionResponse, err := api.CallChatCompletion(
+		openAIToken,
+		modelName,
+		messages,
+		api.ChatOptions{Reasoning: reasoning, Verbosity: verbosity},
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error getting chat completion: %v", err)
+	}
+
+	gptResponseText := "No choices in response"
+	if len(completionResponse.Choices) > 0 {
+		gptResponseText = messageContentToString(completionResponse.Choices[0].Message.Content)
+	}
+
+	return &mentionAnswer{
+		Text:         gptResponseText,
+		Model:        modelName,
+		Question:     text,
+		Media:        media,
+		HistoryLimit: limit,
+		Reasoning:    reasoning,
+		Verbosity:    verbosity,
+	}, nil
+}
+
+// buildMentionMessages assembles the system prompt with the chat history up
+// to the trigger message and the user content for a mention answer.
+func buildMentionMessages(chatID int64, private bool, triggerID int, text string, media []mediaItem, limit int) ([]api.Message, error) {
 	// Prepare the user content for the model (include image if present)
 	var userContent interface{}
-	if len(mediaMessages) > 0 {
-		dataURLs, err := downloadMediaMessagesAsDataURLs(mediaMessages)
+	if len(media) > 0 {
+		dataURLs, err := downloadMediaItemsAsDataURLs(media)
 		if err != nil {
-			log.Printf("Error retrieving media: %v", err)
-			errMsg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("Error processing image: %v", err))
-			sendMessage(errMsg, false)
-			return
+			return nil, fmt.Errorf("Error processing image: %v", err)
 		}
 
 		contentList := []map[string]interface{}{}
@@ -290,38 +534,16 @@ func handleMention(message *tgbotapi.Message) {
 		userContent = text
 	}
 
-	// Determine which model to use (web search or normal) based on triggers
-	replyContext := ""
-	if message.ReplyToMessage != nil {
-		replyContext = message.ReplyToMessage.Text
-	}
-
-	useSearchModel := shouldUseWebSearch(text, replyContext)
-	fmt.Println("useSearchModel: %v\n", useSearchModel)
-	modelName := gptModelForChatting
-	if useSearchModel && len(mediaMessages) == 0 {
-		modelName = gptModelForWebSearch
-	}
-	// Set reasoning/verbosity (as before)
-	var reasoning *string
-	var verbosity *string
-	if !useSearchModel {
-		v, r := "low", "low"
-		verbosity, reasoning = &v, &r
-	}
-
-	limit := 300
-	if useSearchModel {
-		limit = 10
-	}
-
-	messagesString, err := getFormattedMessages(message.Chat.ID, limit)
+	messagesString, err := getFormattedMessages(chatID, limit, triggerID)
 	if err != nil {
-		log.Printf("Error getting formatted messages: %v", err)
-		return
+		return nil, fmt.Errorf("Error getting formatted messages: %v", err)
 	}
 
-	systemPrompt, err := db.GetSystemPrompt(true)
+	getPrompt := db.GetSystemPrompt
+	if private {
+		getPrompt = db.GetPrivatePrompt
+	}
+	systemPrompt, err := getPrompt(true)
 	currentDate := strings.ToUpper(time.Now().Format("02-Jan-2006 15:04:05"))
 	systemPrompt = strings.Replace(systemPrompt, "%current_date%", currentDate, 1)
 	if err != nil {
@@ -332,72 +554,46 @@ func handleMention(message *tgbotapi.Message) {
 	}
 
 	// Create the chat completion request with system prompt and user content
-	messages := []api.Message{
+	return []api.Message{
 		{Role: "system", Content: systemPrompt},
 		{Role: "user", Content: userContent},
-	}
+	}, nil
+}
 
-	completionResponse, err := api.CallChatCompletion(
-		openAIToken,
-		modelName,
-		messages,
-		api.ChatOptions{Reasoning: reasoning, Verbosity: verbosity},
-	)
+// maxReplyChainHops bounds how far up a reply thread the context goes.
+const maxReplyChainHops = 20
 
-	var gptResponseText string
+// getFormattedMessages renders the last limit messages of the chat plus the
+// reply chain of threadMessageID, even if it is older than that window.
+// Messages newer than threadMessageID are left out, so an answer that is
+// regenerated later sees the chat as it was when it was asked.
+func getFormattedMessages(chatId int64, limit int, threadMessageID int) (string, error) {
+	messages, err := db.GetLastMessages(chatId, limit)
 	if err != nil {
-		gptResponseText = fmt.Sprintf("Error getting chat completion: %v\n", err)
-	} else if len(completionResponse.Choices) > 0 {
-		gptResponseText = messageContentToString(completionResponse.Choices[0].Message.Content)
-	} else {
-		gptResponseText = "No choices in response"
+		return "", fmt.Errorf("Error retrieving messages: %v", err)
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, gptResponseText)
-	msg.ReplyToMessageID = message.MessageID
-	msg.ParseMode = tgbotapi.ModeMarkdownV2
-	sendMessage(msg, err == nil)
-}
+	if threadMessageID != 0 {
+		kept := messages[:0]
+		for _, msg := range messages {
+			if msg.MessageID <= threadMessageID {
+				kept = append(kept, msg)
+			}
+		}
+		messages = kept
 
-func getFormattedMessages(chatId int64, limit int) (string, error) {
-	messages, err := db.GetLastMessages(chatId, limit)
-	if err != nil {
-		return "", fmt.Errorf("Error retrieving messages: %v", err)
+		chain, err := db.GetReplyChain(chatId, threadMessageID, maxReplyChainHops)
+		if err != nil {
+			log.Printf("Error retrieving reply chain for msg%d: %v", threadMessageID, err)
+		}
+		messages = mergeMessages(messages, chain)
 	}
+	messages = afterConversationReset(chatId, messages)
 
 	var sb strings.Builder
 
 	for _, msg := range messages {
-		usernamesLock.RLock()
-		username, exists := usernames[msg.UserID]
-		usernamesLock.RUnlock()
-
-		if !exists {
-			chatMemberConfig := tgbotapi.GetChatMemberConfig{
-				ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
-					ChatID: chatId,
-					UserID: msg.UserID,
-				},
-			}
-
-			chatMember, err := bot.GetChatMember(chatMemberConfig)
-			if err != nil {
-				log.Printf("Error getting chat member for user ID %d: %v", msg.UserID, err)
-				username = fmt.Sprintf("User%d", msg.UserID) // Fallback to user ID
-			} else {
-				if chatMember.User.UserName != "" {
-					username = "@" + chatMember.User.UserName
-				} else if chatMember.User.FirstName != "" || chatMember.User.LastName != "" {
-					username = strings.TrimSpace(chatMember.User.FirstName + " " + chatMember.User.LastName)
-				} else {
-					username = fmt.Sprintf("User%d", msg.UserID)
-				}
-			}
-
-			usernamesLock.Lock()
-			usernames[msg.UserID] = username
-			usernamesLock.Unlock()
-		}
+		username := resolveUsername(chatId, msg.UserID)
 
 		formattedDate := msg.Date.Format("02.01.2006 15:04:05")
 
@@ -406,13 +602,45 @@ func getFormattedMessages(chatId int64, limit int) (string, error) {
 			messageText = *msg.AggregatedText
 		}
 
-		messageLine := fmt.Sprintf("msg%d %s %s : %s\n", msg.MessageID, formattedDate, username, messageText)
+		var info string
+		if !msg.EditedAt.IsZero() {
+			info += " (edited)"
+		}
+		if msg.ReplyToMessageID != 0 {
+			info += fmt.Sprintf(" (reply to msg%d)", msg.ReplyToMessageID)
+		}
+
+		messageLine := fmt.Sprintf("msg%d %s %s%s : %s\n", msg.MessageID, formattedDate, username, info, messageText)
 		sb.WriteString(messageLine)
 	}
 
 	return sb.String(), nil
 }
 
+// mergeMessages adds extra messages that are not already in messages and keeps
+// the result ordered by message ID.
+func mergeMessages(messages []db.Message, extra []db.Message) []db.Message {
+	if len(extra) == 0 {
+		return messages
+	}
+
+	seen := make(map[int]bool, len(messages))
+	for _, msg := range messages {
+		seen[msg.MessageID] = true
+	}
+	for _, msg := range extra {
+		if !seen[msg.MessageID] {
+			seen[msg.MessageID] = true
+			messages = append(messages, msg)
+		}
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].MessageID < messages[j].MessageID
+	})
+	return messages
+}
+
 func handleUnknownCommand(message *tgbotapi.Message) {
 	msg := tgbotapi.NewMessage(message.Chat.ID, "Sorry, I don't recognize that command. Type /help to see available commands.")
 	sendMessage(msg, false)
@@ -426,31 +654,72 @@ func saveMessage(message *tgbotapi.Message, texts ...string) {
 		text = message.Text
 	}
 
+	storeMessage(message, text, "", 0)
+}
+
+// saveBotReply stores a bot answer with the model that produced it and the
+// message that triggered it.
+func saveBotReply(reply *tgbotapi.Message, text string, model string, triggerMessageID int) {
+	storeMessage(reply, text, model, triggerMessageID)
+}
+
+// storeMessage queues message for the background writer. Long bot answers
+// are summarized afterwards so the next reply does not wait for the model.
+func storeMessage(message *tgbotapi.Message, text string, model string, triggerMessageID int) {
+	mediaType := messageMediaType(message)
 	if text == "" {
-		log.Printf("Skip saving, empty message from user: %s", message.From.UserName)
+		if mediaType == "" {
+			log.Printf("Skip saving, empty message from user: %s", message.From.UserName)
+			return
+		}
+		// Like imported history, media without a caption is stored by its
+		// type so it shows up in the context and in /search has:….
+		text = "[" + mediaType + "]"
+	}
+
+	var replyToMessageID int
+	if message.ReplyToMessage != nil {
+		replyToMessageID = message.ReplyToMessage.MessageID
+	}
+
+	db.QueueMessage(db.Message{
+		MessageID:        message.MessageID,
+		ChatID:           message.Chat.ID,
+		UserID:           message.From.ID,
+		Text:             text,
+		Date:             message.Time(),
+		ReplyToMessageID: replyToMessageID,
+		TriggerMessageID: triggerMessageID,
+		Model:            model,
+		MediaType:        mediaType,
+	})
+
+	if message.From != nil && message.From.ID == bot.Self.ID {
+		goBackground(func() { fillAggregatedText(message.Chat.ID, message.MessageID, text) })
+	}
+}
+
+// fillAggregatedText stores the summary of a long bot answer once it is ready.
+func fillAggregatedText(chatID int64, messageID int, text string) {
+	aggregatedText := aggregateIfLong(messageID, text)
+	if aggregatedText == nil {
 		return
 	}
+	if err := db.SetAggregatedText(chatID, messageID, *aggregatedText); err != nil {
+		log.Printf("Error saving summary of message %d: %v", messageID, err)
+	}
+}
 
-	var aggregatedText *string
-	isBotMessage := message.From != nil && message.From.ID == bot.Self.ID
-	if isBotMessage && utf8.RuneCountInString(text) > 300 {
-		summary, err := aggregateBotMessage(text)
-		if err != nil {
-			log.Printf("Error aggregating bot message %d: %v", message.MessageID, err)
-		} else {
-			aggregatedText = summary
-		}
+// aggregateIfLong returns a short summary for long bot answers, or nil.
+func aggregateIfLong(messageID int, text string) *string {
+	if utf8.RuneCountInString(text) <= 300 {
+		return nil
 	}
 
-	err := db.SaveMessage(
-		message.MessageID,
-		message.Chat.ID,
-		message.From.ID,
-		text,
-		aggregatedText,
-		message.Date,
-	)
+	summary, err := aggregateBotMessage(text)
 	if err != nil {
-		log.Printf("Error saving message: %v", err)
+		log.Printf("Error aggregating bot message %d: %v", messageID, err)
+		return nil
 	}
+	return summary
 }
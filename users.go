@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"pet.outbid.goapp/db"
+)
+
+// observeUser records the profile of a message author in the users table.
+// Only changes reach the database; the rest is answered from knownUsers.
+func observeUser(from *tgbotapi.User) {
+	if from == nil {
+		return
+	}
+
+	user := db.User{
+		UserID:       from.ID,
+		Username:     from.UserName,
+		FirstName:    from.FirstName,
+		LastName:     from.LastName,
+		LanguageCode: from.LanguageCode,
+		IsBot:        from.IsBot,
+	}
+
+	knownUsersLock.RLock()
+	known, exists := knownUsers[from.ID]
+	knownUsersLock.RUnlock()
+	if exists && known.SameName(user) && known.LanguageCode == user.LanguageCode && known.IsBot == user.IsBot {
+		return
+	}
+
+	if err := db.UpsertUser(user); err != nil {
+		log.Printf("Error saving user %d: %v", from.ID, err)
+		return
+	}
+
+	knownUsersLock.Lock()
+	knownUsers[from.ID] = user
+	knownUsersLock.Unlock()
+}
+
+// lookupUser returns the stored profile of userID, reading through knownUsers.
+func lookupUser(userID int64) (db.User, bool) {
+	knownUsersLock.RLock()
+	user, exists := knownUsers[userID]
+	knownUsersLock.RUnlock()
+	if exists {
+		return user, true
+	}
+
+	user, err := db.GetUser(userID)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error loading user %d: %v", userID, err)
+		}
+		return user, false
+	}
+
+	knownUsersLock.Lock()
+	knownUsers[userID] = user
+	knownUsersLock.Unlock()
+	return user, true
+}
+
+// resolveUsername returns a display name for userID. Telegram is only asked
+// about users the bot has never seen; the answer is stored for next time.
+func resolveUsername(chatID int64, userID int64) string {
+	// Profiles without any name are placeholders stored by older versions
+	// after a failed lookup, so Telegram is asked again.
+	if user, ok := lookupUser(userID); ok && (user.Username != "" || user.FirstName != "" || user.LastName != "") {
+		return userDisplayName(user)
+	}
+	if recentlyFailedLookup(userID) {
+		return userDisplayName(db.User{UserID: userID})
+	}
+
+	chatMember, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatID,
+			UserID: userID,
+		},
+	})
+	if err != nil {
+		log.Printf("Error getting chat member for user ID %d: %v", userID, err)
+		failedUserLookupsLock.Lock()
+		failedUserLookups[userID] = time.Now()
+		failedUserLookupsLock.Unlock()
+		return userDisplayName(db.User{UserID: userID})
+	}
+
+	observeUser(chatMember.User)
+	user, _ := lookupUser(userID)
+	user.UserID = userID
+	return userDisplayName(user)
+}
+
+// recentlyFailedLookup reports whether asking Telegram about userID failed
+// less than failedUserLookupTTL ago.
+func recentlyFailedLookup(userID int64) bool {
+	failedUserLookupsLock.Lock()
+	defer failedUserLookupsLock.Unlock()
+
+	failedAt, ok := failedUserLookups[userID]
+	if !ok {
+		return false
+	}
+	if time.Since(failedAt) >= failedUserLookupTTL {
+		delete(failedUserLookups, userID)
+		return false
+	}
+	return true
+}
+
+// storedDisplayName is resolveUsername without the Telegram fallback, for
+// code that runs without a bot connection.
+func storedDisplayName(userID int64) string {
+	user, _ := lookupUser(userID)
+	user.UserID = userID
+	return userDisplayName(user)
+}
+
+func userDisplayName(user db.User) string {
+	if user.Username != "" {
+		return "@" + user.Username
+	}
+	if user.FirstName != "" || user.LastName != "" {
+		return strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+	return fmt.Sprintf("User%d", user.UserID)
+}
+
+// findUserIDByUsername looks the name up among users seen by the bot.
+func findUserIDByUsername(name string) (int64, bool) {
+	user, err := db.FindUserByUsername(name)
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("Error looking up user %s: %v", name, err)
+		}
+		return 0, false
+	}
+	return user.UserID, true
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"pet.outbid.goapp/db"
 )
 
-func startWebServer() {
+// startWebServer registers the handlers and serves them in the background.
+// It returns the server so shutdown can stop it.
+func startWebServer() *http.Server {
 	username := getStringFromEnv("BASIC_AUTH_USERNAME")
 	password := getStringFromEnv("BASIC_AUTH_PASSWORD")
 
@@ -21,14 +25,27 @@ func startWebServer() {
 
 	http.HandleFunc("/", basicAuth(username, password, indexHandler))
 	http.HandleFunc("/save", basicAuth(username, password, saveHandler))
+	http.HandleFunc("/history", basicAuth(username, password, historyHandler))
+	http.HandleFunc("/diff", basicAuth(username, password, diffHandler))
+	http.HandleFunc("/restore", basicAuth(username, password, restoreHandler))
+	http.HandleFunc("/import", basicAuth(username, password, importHandler))
+	http.HandleFunc("/export", basicAuth(username, password, exportHandler))
+	http.HandleFunc("/metrics", basicAuth(username, password, dispatcher.metricsHandler))
+	if updateMode == updateModeWebhook {
+		http.HandleFunc(webhookPath, webhookHandler)
+	}
 	port := os.Getenv("WEB_SERVER_PORT")
 	if port == "" {
 		port = "8080"
 	}
 	log.Println("Starting web server on : " + port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Printf("Failed to start web server: %v", err)
-	}
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Failed to start web server: %v", err)
+		}
+	}()
+	return server
 }
 
 func basicAuth(username, password string, handler http.HandlerFunc) http.HandlerFunc {
@@ -78,8 +95,15 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 
 	var formTemplate = template.Must(template.New("form").Parse(string(templateContent)))
 
-	promptText, err := db.GetSystemPrompt(false)
-	if err != nil {
+	promptType, ok := formPromptType(r)
+	if !ok {
+		http.Error(w, "Invalid prompt type", http.StatusBadRequest)
+		return
+	}
+
+	// Only the group system prompt is required, other types may be unset.
+	promptText, err := db.GetLatestPrompt(promptType)
+	if err != nil && (promptType == db.PromptTypeSystem || !errors.Is(err, db.ErrNotFound)) {
 		fmt.Println(err)
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
@@ -87,8 +111,12 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 
 	data := struct {
 		Prompt string
+		Type   int
+		Types  map[int]string
 	}{
 		Prompt: promptText,
+		Type:   promptType,
+		Types:  promptTypeNames,
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -108,12 +136,250 @@ func saveHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Prompt cannot be empty", http.StatusBadRequest)
 		return
 	}
+	promptType, ok := formPromptType(r)
+	if !ok {
+		http.Error(w, "Invalid prompt type", http.StatusBadRequest)
+		return
+	}
 
-	err := db.InsertPrompt(prompt, 1)
+	author, _, _ := r.BasicAuth()
+	err := db.InsertPrompt(prompt, promptType, author)
 	if err != nil {
 		http.Error(w, "Failed to save prompt", http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/?type=%d", promptType), http.StatusSeeOther)
+}
+
+// promptTypeNames lists the prompt types that can be edited in the web UI.
+var promptTypeNames = map[int]string{
+	db.PromptTypeSystem:  "System prompt",
+	db.PromptTypePrivate: "Private chat prompt",
+}
+
+// formPromptType reads the "type" form value, the group system prompt when
+// it is not set.
+func formPromptType(r *http.Request) (int, bool) {
+	value := r.FormValue("type")
+	if value == "" {
+		return db.PromptTypeSystem, true
+	}
+	promptType, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	_, ok := promptTypeNames[promptType]
+	return promptType, ok
+}
+
+func renderTemplate(w http.ResponseWriter, file string, data any) {
+	templateContent, err := os.ReadFile(file)
+	if err != nil {
+		log.Printf("Error reading template %s: %v", file, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.New(file).Parse(string(templateContent))
+	if err != nil {
+		log.Printf("Error parsing template %s: %v", file, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := tmpl.Execute(w, data); err != nil {
+		http.Error(w, "Failed to render template", http.StatusInternalServerError)
+	}
+}
+
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	promptType, ok := formPromptType(r)
+	if !ok {
+		http.Error(w, "Invalid prompt type", http.StatusBadRequest)
+		return
+	}
+
+	prompts, err := db.ListPrompts(promptType)
+	if err != nil {
+		log.Printf("Error listing prompts: %v", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	type version struct {
+		db.Prompt
+		Preview string
+		Latest  bool
+	}
+	versions := make([]version, len(prompts))
+	for i, p := range prompts {
+		preview, _, _ := strings.Cut(strings.TrimSpace(p.Text), "\n")
+		if len([]rune(preview)) > 80 {
+			preview = string([]rune(preview)[:80]) + "…"
+		}
+		versions[i] = version{Prompt: p, Preview: preview, Latest: i == 0}
+	}
+
+	renderTemplate(w, "web/history.html", struct {
+		Type     int
+		Types    map[int]string
+		Versions []version
+	}{
+		Type:     promptType,
+		Types:    promptTypeNames,
+		Versions: versions,
+	})
+}
+
+// diffHandler compares a prompt version (id) with another one (against),
+// by default the version saved right before it.
+func diffHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid prompt ID", http.StatusBadRequest)
+		return
+	}
+	current, err := db.GetPrompt(id)
+	if err != nil {
+		http.Error(w, "Prompt version not found", http.StatusNotFound)
+		return
+	}
+
+	var previous db.Prompt
+	if value := r.FormValue("against"); value != "" {
+		againstID, err := strconv.Atoi(value)
+		if err != nil {
+			http.Error(w, "Invalid prompt ID", http.StatusBadRequest)
+			return
+		}
+		if previous, err = db.GetPrompt(againstID); err != nil {
+			http.Error(w, "Prompt version not found", http.StatusNotFound)
+			return
+		}
+	} else {
+		prompts, err := db.ListPrompts(current.Type)
+		if err != nil {
+			log.Printf("Error listing prompts: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		for _, p := range prompts {
+			if p.ID < current.ID {
+				previous = p
+				break
+			}
+		}
+	}
+
+	renderTemplate(w, "web/diff.html", struct {
+		Old  db.Prompt
+		New  db.Prompt
+		Rows []diffRow
+	}{
+		Old:  previous,
+		New:  current,
+		Rows: sideBySideDiff(previous.Text, current.Text),
+	})
+}
+
+// restoreHandler saves an old version as the newest one, so the restore
+// itself shows up in the history.
+func restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid prompt ID", http.StatusBadRequest)
+		return
+	}
+	p, err := db.GetPrompt(id)
+	if err != nil {
+		http.Error(w, "Prompt version not found", http.StatusNotFound)
+		return
+	}
+
+	author, _, _ := r.BasicAuth()
+	if err := db.InsertPrompt(p.Text, p.Type, author); err != nil {
+		http.Error(w, "Failed to restore prompt", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Prompt #%d (type %d) restored by %s", p.ID, p.Type, author)
+
+	http.Redirect(w, r, fmt.Sprintf("/history?type=%d", p.Type), http.StatusSeeOther)
+}
+
+func importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, _, err := r.FormFile("export")
+	if err != nil {
+		http.Error(w, "Export file is required", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	var chatID int64
+	if value := r.FormValue("chat_id"); value != "" {
+		chatID, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := importTelegramExport(file, chatID)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, result := range results {
+		fmt.Fprintf(w, "Chat %d: %d messages, %d imported, %d already stored\n",
+			result.ChatID, result.Total, result.Imported, result.Skipped)
+	}
+	if err != nil {
+		log.Printf("Import failed: %v", err)
+		fmt.Fprintf(w, "Import failed: %v\n", err)
+	}
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid chat ID", http.StatusBadRequest)
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = exportFormatJSONL
+	}
+
+	var fileName, contentType string
+	switch format {
+	case exportFormatJSONL:
+		fileName = fmt.Sprintf("chat_%d.jsonl", chatID)
+		contentType = "application/x-ndjson"
+	case exportFormatTelegram:
+		fileName = fmt.Sprintf("chat_%d_result.json", chatID)
+		contentType = "application/json"
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, use %s or %s", format, exportFormatJSONL, exportFormatTelegram),
+			http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	displayName := func(userID int64) string {
+		return resolveUsername(chatID, userID)
+	}
+	if err := exportHistory(w, chatID, format, displayName); err != nil {
+		log.Printf("Export failed: %v", err)
+		http.Error(w, "Export failed", http.StatusInternalServerError)
+	}
 }
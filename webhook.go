@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	updateModePolling = "polling"
+	updateModeWebhook = "webhook"
+
+	defaultWebhookPath = "/telegram/webhook"
+)
+
+// Webhook configuration, read by initWebhookConfig. In webhook mode updates
+// are served by startWebServer on webhookPath.
+var (
+	updateMode     string
+	webhookURL     string
+	webhookPath    string
+	webhookSecret  string
+	webhookUpdates = make(chan tgbotapi.Update, 100)
+)
+
+// initWebhookConfig reads BOT_UPDATE_MODE ("polling" by default or
+// "webhook"). Webhook mode needs WEBHOOK_URL, the public base URL of the web
+// server, and WEBHOOK_SECRET; WEBHOOK_PATH defaults to /telegram/webhook.
+func initWebhookConfig() {
+	updateMode = os.Getenv("BOT_UPDATE_MODE")
+	if updateMode == "" {
+		updateMode = updateModePolling
+	}
+
+	switch updateMode {
+	case updateModePolling:
+	case updateModeWebhook:
+		webhookURL = strings.TrimSuffix(getStringFromEnv("WEBHOOK_URL"), "/")
+		webhookSecret = getStringFromEnv("WEBHOOK_SECRET")
+		webhookPath = os.Getenv("WEBHOOK_PATH")
+		if webhookPath == "" {
+			webhookPath = defaultWebhookPath
+		}
+	default:
+		log.Fatalf("Invalid BOT_UPDATE_MODE %q, expected %q or %q", updateMode, updateModePolling, updateModeWebhook)
+	}
+	log.Printf("Receiving updates via %s", updateMode)
+}
+
+// updatesChannel switches Telegram to the configured mode and returns the
+// channel updates arrive on. Telegram refuses getUpdates while a webhook is
+// set, so polling mode deletes any webhook left from an earlier run.
+func updatesChannel() tgbotapi.UpdatesChannel {
+	if updateMode == updateModeWebhook {
+		if err := setWebhook(); err != nil {
+			log.Fatalf("Failed to set webhook: %v", err)
+		}
+		log.Printf("Webhook set to %s%s", webhookURL, webhookPath)
+		return webhookUpdates
+	}
+
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		log.Printf("Error getting webhook info: %v", err)
+	}
+	if err != nil || info.IsSet() {
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Fatalf("Failed to delete webhook: %v", err)
+		}
+		log.Println("Webhook deleted, switching to long polling")
+	}
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+	return bot.GetUpdatesChan(u)
+}
+
+// setWebhook registers the webhook with its secret token. WebhookConfig in
+// the library has no secret_token field, so the request is built by hand.
+func setWebhook() error {
+	params := make(tgbotapi.Params)
+	params["url"] = webhookURL + webhookPath
+	params["secret_token"] = webhookSecret
+	_, err := bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+// webhookHandler accepts updates pushed by Telegram. Requests without the
+// secret token are rejected before the body is read.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(webhookSecret)) != 1 {
+		log.Printf("Rejected webhook request from %s: bad secret token", r.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	update, err := bot.HandleUpdate(r)
+	if err != nil {
+		log.Printf("Error decoding webhook update: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	select {
+	case webhookUpdates <- *update:
+	case <-shuttingDown:
+		// Telegram retries the update after the restart.
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}